@@ -22,7 +22,9 @@ import (
 	"github.com/gin-gonic/gin"
 	v1 "kcaitech.com/kcserver/api/v1"
 	handlers "kcaitech.com/kcserver/handlers"
+	"kcaitech.com/kcserver/handlers/common"
 	"kcaitech.com/kcserver/middlewares"
+	"kcaitech.com/kcserver/providers/storage"
 	"kcaitech.com/kcserver/services"
 )
 
@@ -63,14 +65,31 @@ func onNotFound(webFilePath string) func(c *gin.Context) {
 	}
 }
 
+// 压缩会破坏Range请求，代理的对象原样返回
+func gzipExcept(prefixes ...string) gin.HandlerFunc {
+	handler := gzip.Gzip(gzip.DefaultCompression)
+	return func(c *gin.Context) {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				return
+			}
+		}
+		handler(c)
+	}
+}
+
 func LoadRoutes(router *gin.Engine, webFilePath string) {
 	router.RedirectTrailingSlash = false
 	router.GET("/health", handlers.HealthCheck)
-	router.Use(gzip.Gzip(gzip.DefaultCompression))
+	config := services.GetConfig()
+	if config.Storage.Provider == storage.LOCAL {
+		// 在gzip之前注册，避免压缩破坏Range请求；凭令牌访问，允许跨域
+		router.Any("/api/storage/:bucket/*key", middlewares.CORSMiddleware(), handlers.LocalStorageObject)
+	}
+	router.Use(gzipExcept(common.StorageProxyEndpoint + "/"))
 	router.Use(static.Serve("/", static.LocalFile(webFilePath, false))) // 前端工程
 	router.NoRoute(onNotFound(webFilePath))
 
-	config := services.GetConfig()
 	if config.Middleware.DebugLog {
 		router.Use(middlewares.AccessDetailedLogMiddleware())
 	} else {
@@ -80,10 +99,12 @@ func LoadRoutes(router *gin.Engine, webFilePath string) {
 		router.Use(middlewares.CORSMiddleware()) // 测试时需要
 	}
 
-	router.Use(middlewares.NewRateLimiter(&middlewares.RedisStore{
-		Client: services.GetRedisDB().Client,
-		Ctx:    context.Background(),
-	}, middlewares.DefaultRateLimiterConfig()).RateLimitMiddleware())
+	if services.HasRedisDB() {
+		router.Use(middlewares.NewRateLimiter(&middlewares.RedisStore{
+			Client: services.GetRedisDB().Client,
+			Ctx:    context.Background(),
+		}, middlewares.DefaultRateLimiterConfig()).RateLimitMiddleware())
+	}
 
 	apiGroup := router.Group("/api")
 	apiGroup.GET("/version.json", func(c *gin.Context) {
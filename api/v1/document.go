@@ -39,6 +39,15 @@ func loadDocumentRoutes(api *gin.RouterGroup) {
 	router.POST("/resource", handlers.CreateResourceDocument)                   // 创建资源文档
 	router.POST("/review", common.ReReviewDocument)                             // todo: 重新审核文档
 	router.GET("/thumbnail_access_key", handlers.GetDocumentThumbnailAccessKey) // 获取文档缩略图
+	router.GET("/objects/*key", handlers.GetDocumentObject)                     // 代理读取文档对象
+	router.HEAD("/objects/*key", handlers.GetDocumentObject)
+	// 版本
+	router.GET("/versions", handlers.GetDocumentVersionList)                 // 获取文档版本列表
+	router.PUT("/versions", handlers.SetDocumentVersionInfo)                 // 设置版本名称及描述
+	router.PUT("/versions/pin", handlers.SetDocumentVersionPinned)           // 固定版本
+	router.GET("/versions/access_key", handlers.GetDocumentVersionAccessKey) // 获取历史版本密钥
+	router.POST("/versions/restore", handlers.RestoreDocumentVersion)        // 恢复到历史版本
+	router.POST("/versions/snapshot", handlers.CreateDocumentSnapshot)       // 立即生成新版本
 	// 评论
 	router.GET("/comments", handlers.GetDocumentComment)         // 获取文档评论
 	router.POST("/comment", handlers.PostUserComment)            // 创建评论
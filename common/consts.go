@@ -11,14 +11,19 @@
 package common
 
 const (
-	RedisKeyDocumentVersioningLastUpdateTime = "server_document_version_last_update:"
-	RedisKeyDocumentVersioningMutex          = "server_document_version_mutex:"
-	RedisKeyDocumentVersion                  = "server_document_version:"
-	RedisKeyDocumentLastCmdVerId             = "server_document_last_cmd_ver_id:"
-	RedisKeyDocumentComment                  = "server_document_comment:"
-	RedisKeyDocumentOpMutex                  = "server_document_op_mutex:"
-	RedisKeyDocumentOp                       = "server_document_op:"
-	RedisKeyDocumentSelection                = "server_document_selection:"
-	RedisKeyDocumentSelectionData            = "server_document_selection_data:"
-	RedisKeyRateLimit                        = "server_ratelimit:"
+	RedisKeyDocumentVersioningQueue      = "server_document_versioning_queue"
+	RedisKeyDocumentVersioningForceQueue = "server_document_versioning_force_queue"
+	RedisKeyDocumentVersioningMutex      = "server_document_version_mutex:"
+	RedisKeyDocumentVersion              = "server_document_version:"
+	RedisKeyDocumentComment              = "server_document_comment:"
+	RedisKeyDocumentOpMutex              = "server_document_op_mutex:"
+	RedisKeyDocumentCmdCompactionMutex   = "server_document_cmd_compaction_mutex:"
+	RedisKeyDocumentPurgeMutex           = "server_document_purge_mutex:"
+	RedisKeyDocumentOp                   = "server_document_op:"
+	RedisKeyDocumentSelection            = "server_document_selection:"
+	RedisKeyDocumentSelectionData        = "server_document_selection_data:"
+	RedisKeyDocumentViewport             = "server_document_viewport:"
+	RedisKeyDocumentBroadcast            = "server_document_broadcast:"
+	RedisKeyDocumentPermission           = "server_document_permission:"
+	RedisKeyRateLimit                    = "server_ratelimit:"
 )
@@ -16,6 +16,8 @@ import (
 	"os"
 
 	"gopkg.in/yaml.v2"
+	broker "kcaitech.com/kcserver/providers/broker"
+	bus "kcaitech.com/kcserver/providers/bus"
 	mongo "kcaitech.com/kcserver/providers/mongo"
 	redis "kcaitech.com/kcserver/providers/redis"
 	safereview "kcaitech.com/kcserver/providers/safereview"
@@ -69,10 +71,37 @@ type Configuration struct {
 		Url               string `yaml:"url" json:"url"`
 		MinUpdateInterval int    `yaml:"min_update_interval" json:"min_update_interval"`
 		MinCmdCount       int    `yaml:"min_cmd_count" json:"min_cmd_count"`
+		Builtin           bool   `yaml:"builtin" json:"builtin"`               // 优先在服务端应用cmd生成版本，只支持已有页面内的属性设置，其它cmds仍请求url
+		Workers           int    `yaml:"workers" json:"workers"`               // 每个实例生成版本的并发数
+		MaxRetry          int    `yaml:"max_retry" json:"max_retry"`           // 失败重试次数
+		RetryInterval     int    `yaml:"retry_interval" json:"retry_interval"` // 首次重试间隔（秒），之后翻倍
 	} `yaml:"doc_update_server" json:"doc_update_server"`
+	CmdCompaction struct {
+		Enable         bool   `yaml:"enable" json:"enable"`
+		Mode           string `yaml:"mode" json:"mode"`                         // archive | delete
+		RetainCmdCount int    `yaml:"retain_cmd_count" json:"retain_cmd_count"` // 快照之前保留的cmd数量
+		RetainHours    int    `yaml:"retain_hours" json:"retain_hours"`         // 保留最近多少小时内的cmd
+		Interval       int    `yaml:"interval" json:"interval"`                 // 定期压缩的间隔（秒）
+	} `yaml:"cmd_compaction" json:"cmd_compaction"`
+	WebSocket struct {
+		PingInterval int `yaml:"ping_interval" json:"ping_interval"` // 服务端ping间隔（秒）
+		PongTimeout  int `yaml:"pong_timeout" json:"pong_timeout"`   // 未收到任何数据多久后断开（秒）
+		IdleTimeout  int `yaml:"idle_timeout" json:"idle_timeout"`   // 无业务消息多久后断开（秒），0为不限制
+		ResumeGrace  int `yaml:"resume_grace" json:"resume_grace"`   // 断开后会话保留多久（秒），0为不支持恢复
+	} `yaml:"websocket" json:"websocket"`
+	RecycleBin struct {
+		RetentionDays int `yaml:"retention_days" json:"retention_days"` // 回收站保留天数，过期后彻底删除，0为不自动删除
+		Interval      int `yaml:"interval" json:"interval"`             // 检查过期的间隔（秒）
+	} `yaml:"recycle_bin" json:"recycle_bin"`
+	StorageProxy struct {
+		Enable bool `yaml:"enable" json:"enable"`   // 由服务端代理读取文档对象，不再下发存储的临时密钥
+		MaxAge int  `yaml:"max_age" json:"max_age"` // 当前版本对象的客户端缓存时间（秒），0为每次协商缓存
+	} `yaml:"storage_proxy" json:"storage_proxy"`
 
 	Mongo      mongo.MongoConf           `yaml:"mongo" json:"mongo"`
 	Redis      redis.RedisConf           `yaml:"redis" json:"redis"`
+	Broker     broker.Config             `yaml:"broker" json:"broker"`
+	Bus        bus.Config                `yaml:"bus" json:"bus"`
 	SafeReview safereview.SafeReviewConf `yaml:"safe_review" json:"safe_review"`
 	Storage    storage.Config            `yaml:"storage" json:"storage"`
 
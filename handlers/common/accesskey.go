@@ -36,6 +36,20 @@ type AccessKeyInfo struct {
 	Endpoint        string `json:"endpoint"`
 }
 
+const (
+	StorageProxyProvider = "proxy"
+	StorageProxyEndpoint = "/api/v1/documents/objects"
+)
+
+// 代理模式下不下发密钥，客户端经StorageProxyEndpoint读取对象
+func StorageProxyAccessKeyInfo() *AccessKeyInfo {
+	return &AccessKeyInfo{
+		Provider:   StorageProxyProvider,
+		BucketName: services.GetStorageClient().Bucket.GetConfig().DocumentBucket,
+		Endpoint:   StorageProxyEndpoint,
+	}
+}
+
 // GetDocumentAccessKey 获取文档访问密钥
 func GetDocumentAccessKey(userId string, documentId string, retPublicEndpoint bool) (*AccessKeyInfo, int, error) {
 	documentService := services.NewDocumentService()
@@ -76,19 +90,6 @@ func GetDocumentAccessKey(userId string, documentId string, retPublicEndpoint bo
 		}
 	}
 
-	_storage := services.GetStorageClient()
-	accessKeyValue, err := _storage.Bucket.GenerateAccessKey(
-		document.Path+"/*",
-		storage.AuthOpGetObject|storage.AuthOpListObject,
-		3600,
-		"U"+(userId)+"D"+(documentId),
-	)
-	if err != nil {
-		log.Println("生成密钥失败", err)
-		// response.Fail(c, "生成密钥失败")
-		return nil, 0, fmt.Errorf("生成密钥失败")
-	}
-
 	// 插入/更新访问记录
 	now := (time.Now())
 	documentAccessRecord := models.DocumentAccessRecord{}
@@ -110,6 +111,24 @@ func GetDocumentAccessKey(userId string, documentId string, retPublicEndpoint bo
 		}, "id = ?", documentAccessRecord.Id, &services.Unscoped{})
 	}
 
+	// 服务端的ws仍直连存储
+	if retPublicEndpoint && services.GetConfig().StorageProxy.Enable {
+		return StorageProxyAccessKeyInfo(), http.StatusOK, nil
+	}
+
+	_storage := services.GetStorageClient()
+	accessKeyValue, err := _storage.Bucket.GenerateAccessKey(
+		document.Path+"/*",
+		storage.AuthOpGetObject|storage.AuthOpListObject,
+		3600,
+		"U"+(userId)+"D"+(documentId),
+	)
+	if err != nil {
+		log.Println("生成密钥失败", err)
+		// response.Fail(c, "生成密钥失败")
+		return nil, 0, fmt.Errorf("生成密钥失败")
+	}
+
 	storageConfig := _storage.Bucket.GetConfig()
 	documentStorageUrl := services.GetConfig().StorageUrl.Document
 	if !retPublicEndpoint {
@@ -163,6 +182,13 @@ func GetDocumentThumbnailAccessKeyNoCheckAuth(c *gin.Context, documentId string,
 			continue
 		}
 
+		if services.GetConfig().StorageProxy.Enable {
+			return &ThumbnailResponse{
+				AccessKeyInfo: *StorageProxyAccessKeyInfo(),
+				ObjectKey:     object.Key,
+			}, nil
+		}
+
 		// 生成预签名URL，有效期1小时
 		reqParams := make(url.Values)
 		reqParams.Set("response-content-disposition", "inline")
@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -21,11 +22,10 @@ import (
 	"os"
 	"time"
 
-	"github.com/go-redsync/redsync/v4"
 	"kcaitech.com/kcserver/common"
 	"kcaitech.com/kcserver/models"
-	"kcaitech.com/kcserver/providers/redis"
-	"kcaitech.com/kcserver/utils/my_map"
+	"kcaitech.com/kcserver/providers/bus"
+	"kcaitech.com/kcserver/utils/sliceutil"
 
 	// "kcaitech.com/kcserver/common"
 	config "kcaitech.com/kcserver/config"
@@ -33,24 +33,6 @@ import (
 	// document "kcaitech.com/kcserver/handlers/document"
 )
 
-// 最短更新时间间隔（秒）
-// const minUpdateTimeInterval time.Duration = time.Second * 60 * 10
-
-type DocumentVersioningInfo struct {
-	DocId          string    `json:"docId"`
-	LastUpdateTime time.Time `json:"lastUpdateTime"`
-}
-
-// 并不是同一个文档的都在一个服务实例里，也就个人编辑有点用
-var documentVersioningInfoMap = my_map.NewSyncMap[string, DocumentVersioningInfo]()
-
-func getDocumentLastUpdateTimeFromRedis(documentId string, redis *redis.RedisDB) time.Time {
-	if lastUpdateTime, err := redis.Client.Get(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentVersioningLastUpdateTime, documentId)).Int64(); err == nil && lastUpdateTime > 0 {
-		return time.UnixMilli(lastUpdateTime)
-	}
-	return time.UnixMilli(0)
-}
-
 type DocumentInfo struct {
 	DocumentId string `json:"id"` // 可能是int
 	Path       string `json:"path"`
@@ -73,55 +55,127 @@ type VersionResp struct {
 	TmpPngDir    string     `json:"tmp_png_dir"`
 }
 
-func AutoUpdate(documentId string, config *config.Configuration) {
-	info, ok := documentVersioningInfoMap.Get(documentId)
-	if !ok {
-		info = DocumentVersioningInfo{
-			DocId:          documentId,
-			LastUpdateTime: time.UnixMilli(0),
+const (
+	versioningPollInterval   = time.Second
+	versioningBusyDelay      = time.Second * 5
+	defaultVersioningWorkers = 2
+	defaultVersioningRetry   = 5
+	maxVersioningRetryDelay  = time.Minute * 10
+	versioningVisibility     = time.Minute * 15 // 认领后多久未完成视为实例已退出，需大于重试间隔
+)
+
+var ErrVersioningBusy = errors.New("文档正在生成版本")
+
+// 标记文档有新的cmd，在min_update_interval之后生成版本
+// 已在队列中时保留较早的时间，持续编辑的文档也会按间隔生成版本
+func MarkDocumentDirty(documentId string, config *config.Configuration) {
+	at := time.Now().Add(time.Second * time.Duration(config.VersionServer.MinUpdateInterval))
+	if err := services.GetBus().Schedule(context.Background(), common.RedisKeyDocumentVersioningQueue, documentId, at); err != nil {
+		log.Println("加入版本队列失败", documentId, err)
+	}
+}
+
+// 立即生成版本，不受min_cmd_count限制
+func ForceDocumentVersion(documentId string) error {
+	return services.GetBus().Schedule(context.Background(), common.RedisKeyDocumentVersioningForceQueue, documentId, time.Now())
+}
+
+// 启动版本生成的worker，各实例从共享的队列中认领文档
+func RunVersioningScheduler(config *config.Configuration) {
+	workers := config.VersionServer.Workers
+	if workers <= 0 {
+		workers = defaultVersioningWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go versioningWorker(config)
+	}
+}
+
+func versioningWorker(config *config.Configuration) {
+	for {
+		queue, task, ok := popVersioningTask()
+		if !ok {
+			time.Sleep(versioningPollInterval)
+			continue
+		}
+		documentId := task.Member
+		force := queue == common.RedisKeyDocumentVersioningForceQueue
+		err := UpdateDocumentVersion(documentId, force, config)
+		if err == nil {
+			ackVersioning(queue, task)
+			continue
+		}
+		if errors.Is(err, ErrVersioningBusy) {
+			rescheduleVersioning(queue, documentId, versioningBusyDelay)
+			continue
+		}
+		// 失败次数记录在bus中，各实例共享
+		retry, failErr := services.GetBus().Fail(context.Background(), queue, documentId)
+		if failErr != nil {
+			log.Println("记录版本生成失败次数失败", documentId, failErr)
+			retry = 1
+		}
+		maxRetry := config.VersionServer.MaxRetry
+		if maxRetry <= 0 {
+			maxRetry = defaultVersioningRetry
+		}
+		if retry > maxRetry {
+			log.Println("生成版本失败，放弃重试", documentId, err)
+			ackVersioning(queue, task)
+			continue
+		}
+		delay := min(time.Second*time.Duration(max(config.VersionServer.RetryInterval, 1))<<min(retry-1, 16), maxVersioningRetryDelay)
+		log.Println("生成版本失败，稍后重试", documentId, retry, delay, err)
+		rescheduleVersioning(queue, documentId, delay)
+	}
+}
+
+// 优先处理强制生成的，认领期间实例退出时任务到期后由其它实例重新取出
+func popVersioningTask() (string, bus.QueueTask, bool) {
+	for _, queue := range []string{common.RedisKeyDocumentVersioningForceQueue, common.RedisKeyDocumentVersioningQueue} {
+		tasks, err := services.GetBus().PopDue(context.Background(), queue, 1, versioningVisibility)
+		if err != nil {
+			log.Println("获取版本队列失败", queue, err)
+			continue
+		}
+		if len(tasks) > 0 {
+			return queue, tasks[0], true
 		}
-		documentVersioningInfoMap.Set(documentId, info)
 	}
-	minUpdateTimeInterval := time.Second * time.Duration(config.VersionServer.MinUpdateInterval)
-	// 时间未到
-	if time.Since(info.LastUpdateTime) < minUpdateTimeInterval {
-		return
+	return "", bus.QueueTask{}, false
+}
+
+func ackVersioning(queue string, task bus.QueueTask) {
+	if err := services.GetBus().Ack(context.Background(), queue, task); err != nil {
+		log.Println("移出版本队列失败", task.Member, err)
 	}
+}
+
+func rescheduleVersioning(queue string, documentId string, delay time.Duration) {
+	if err := services.GetBus().Schedule(context.Background(), queue, documentId, time.Now().Add(delay)); err != nil {
+		log.Println("加入版本队列失败", documentId, err)
+	}
+}
+
+// 生成文档的新版本，没有需要处理的cmd时返回nil
+func UpdateDocumentVersion(documentId string, force bool, config *config.Configuration) error {
 	// 上锁
-	// documentIdStr := str.IntToString(documentId)
-	redis := services.GetRedisDB()
-	documentVersioningMutex := redis.RedSync.NewMutex(fmt.Sprintf("%s%s", common.RedisKeyDocumentVersioningMutex, documentId), redsync.WithExpiry(time.Second*10))
+	documentVersioningMutex := services.GetBus().NewMutex(fmt.Sprintf("%s%s", common.RedisKeyDocumentVersioningMutex, documentId), time.Minute*2)
 	if err := documentVersioningMutex.TryLock(); err != nil {
-		info.LastUpdateTime = time.Now()
-		return
+		return ErrVersioningBusy
 	}
 	defer func() {
 		if _, err := documentVersioningMutex.Unlock(); err != nil {
 			log.Println(documentId, "释放锁失败 documentVersioningMutex.Unlock", err)
 		}
 	}()
-	// 从redis获取LastUpdateTime，更新到本地缓存
-	lastUpdateTimeFromRedis := getDocumentLastUpdateTimeFromRedis(documentId, redis)
-	if !lastUpdateTimeFromRedis.IsZero() {
-		info.LastUpdateTime = lastUpdateTimeFromRedis
-	}
-	// 再检测一遍，时间未到
-	if time.Since(info.LastUpdateTime) < minUpdateTimeInterval {
-		return
-	}
-	// 开始更新版本
-	defer func() {
-		info.LastUpdateTime = time.Now()
-	}()
 
 	log.Println("auto update document:", documentId)
-	var generateApiUrl = config.VersionServer.Url
 
 	documentInfo, err := GetDocumentBasicInfoById(documentId)
 
 	if err != nil {
-		log.Println("获取文档信息失败: " + err.Error())
-		return
+		return fmt.Errorf("获取文档信息失败: %w", err)
 	}
 
 	cmdService := services.GetCmdService()
@@ -129,19 +183,95 @@ func AutoUpdate(documentId string, config *config.Configuration) {
 	cmdItemList, err := cmdService.GetCmdItemsFromStart(documentId, lastCmdId)
 
 	if err != nil {
-		log.Println("获取命令列表失败: " + err.Error())
-		return
+		return fmt.Errorf("获取命令列表失败: %w", err)
 	}
 
 	if len(cmdItemList) == 0 {
 		log.Println("没有命令需要更新版本")
-		return
+		return nil
+	}
+
+	if restored, err := isDocumentRestored(documentId, cmdItemList); err != nil {
+		return err
+	} else if restored {
+		// 快照已由恢复操作生成
+		log.Println("文档已恢复版本，不更新版本", documentId)
+		return nil
+	}
+	// 未完成的恢复留下的标记，之后的cmd仍基于恢复前的快照
+	cmdItemList = sliceutil.FilterT(func(item models.CmdItem) bool {
+		return !item.Cmd.IsRestore()
+	}, cmdItemList...)
+	if len(cmdItemList) == 0 {
+		return nil
 	}
 
-	if len(cmdItemList) < config.VersionServer.MinCmdCount {
+	if !force && len(cmdItemList) < config.VersionServer.MinCmdCount {
 		log.Println("命令数量小于", config.VersionServer.MinCmdCount, "不更新版本")
-		return
+		return nil
+	}
+	var version *VersionResp
+	// 审核需要页面png，只能由version server生成
+	if config.VersionServer.Builtin && services.GetSafereviewClient() == nil {
+		if version, err = generateVersion(documentInfo, cmdItemList); err != nil {
+			log.Println("服务端生成版本失败，交给version server处理", documentId, err)
+			version = nil
+		}
+	}
+	if version == nil {
+		if version, err = requestVersionServer(config, documentInfo, cmdItemList); err != nil {
+			return err
+		}
+	}
+
+	log.Println("auto update document, start upload data", documentId)
+	// upload document data
+	// header := Header{
+	// 	DocumentId:   documentId,
+	// 	LastCmdVerId: version.LastCmdVerId,
+	// }
+	response := Response{}
+	UpdateDocumentData(documentId, version.LastCmdVerId, version, nil, &response)
+
+	if response.Code != http.StatusOK {
+		return errors.New("UploadDocumentData fail " + response.Message)
+	}
+
+	if publishData, err := json.Marshal(&models.DocumentVersionWSData{
+		DocumentId:       documentId,
+		VersionId:        documentInfo.VersionId,
+		VersionStartWith: lastCmdId,
+	}); err == nil {
+		services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentVersion, documentId), publishData)
+	}
+
+	go CompactDocumentCmds(documentId, config)
+
+	log.Println("auto update successed")
+	return nil
+}
+
+// 待处理的cmd中有恢复标记，且标记的快照已是文档的当前版本（恢复在读取文档信息之后完成）
+func isDocumentRestored(documentId string, cmdItemList []models.CmdItem) (bool, error) {
+	var restoreVersionId string
+	for _, item := range cmdItemList {
+		if item.Cmd.IsRestore() {
+			restoreVersionId = item.Cmd.RestoreVersionId()
+		}
+	}
+	if restoreVersionId == "" {
+		return false, nil
+	}
+	documentInfo, err := GetDocumentBasicInfoById(documentId)
+	if err != nil {
+		return false, fmt.Errorf("获取文档信息失败: %w", err)
 	}
+	return documentInfo.VersionId == restoreVersionId, nil
+}
+
+// 由version server生成新版本的数据，需要审核时同时生成页面png
+func requestVersionServer(config *config.Configuration, documentInfo *DocumentInfo, cmdItemList []models.CmdItem) (*VersionResp, error) {
+	var generateApiUrl = config.VersionServer.Url
 	// 构建请求
 	reqBody := map[string]interface{}{
 		"documentInfo": documentInfo,
@@ -149,7 +279,7 @@ func AutoUpdate(documentId string, config *config.Configuration) {
 		// "force":        false,
 	}
 
-	tmpPngDir := config.SafeReview.TmpPngDir + "/" + documentId
+	tmpPngDir := config.SafeReview.TmpPngDir + "/" + documentInfo.DocumentId
 	reviewClient := services.GetSafereviewClient()
 	if reviewClient != nil { // 需要审查才生成png图片
 		reqBody["gen_pages_png"] = map[string]interface{}{
@@ -160,63 +290,29 @@ func AutoUpdate(documentId string, config *config.Configuration) {
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		log.Println("Failed to marshal request body:", err)
-		return
+		return nil, fmt.Errorf("Failed to marshal request body: %w", err)
 	}
 
 	resp, err := http.Post(generateApiUrl, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
-		log.Println(generateApiUrl, "http.NewRequest err", err)
-		return
+		return nil, fmt.Errorf("%s http.NewRequest err %w", generateApiUrl, err)
 	}
 
 	defer resp.Body.Close()
 	// 读取响应
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println(generateApiUrl, "io.ReadAll err", err)
-		return
+		return nil, fmt.Errorf("%s io.ReadAll err %w", generateApiUrl, err)
 	}
 	if resp.StatusCode != 200 {
-		log.Println(generateApiUrl, "请求失败", resp.StatusCode, string(body))
-		return
+		return nil, fmt.Errorf("%s 请求失败 %d %s", generateApiUrl, resp.StatusCode, string(body))
 	}
 	version := VersionResp{}
 	err = json.Unmarshal(body, &version)
 	if err != nil {
-		log.Println(generateApiUrl, "resp", err)
-		return
+		return nil, fmt.Errorf("%s resp %w", generateApiUrl, err)
 	}
 
 	version.TmpPngDir = tmpPngDir
-
-	log.Println("auto update document, start upload data", documentId)
-	// upload document data
-	// header := Header{
-	// 	DocumentId:   documentId,
-	// 	LastCmdVerId: version.LastCmdVerId,
-	// }
-	response := Response{}
-	UpdateDocumentData(documentId, version.LastCmdVerId, &version, nil, &response)
-
-	if response.Code != http.StatusOK {
-		log.Println("UploadDocumentData fail")
-		return
-	}
-
-	if publishData, err := json.Marshal(&models.DocumentVersionWSData{
-		DocumentId:       documentId,
-		VersionId:        documentInfo.VersionId,
-		VersionStartWith: lastCmdId,
-	}); err == nil {
-		redisClient := services.GetRedisDB()
-		redisClient.Client.Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentVersion, documentId), publishData)
-	}
-
-	// 更新redis
-	if _, err := redis.Client.Set(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentVersioningLastUpdateTime, documentId), time.Now().UnixMilli(), time.Hour*1).Result(); err != nil {
-		log.Println("redis.Client.Set err", err)
-	} else {
-		log.Println("auto update successed")
-	}
+	return &version, nil
 }
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"kcaitech.com/kcserver/common"
+	config "kcaitech.com/kcserver/config"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/services"
+)
+
+const defaultCmdCompactionInterval = time.Hour
+
+// 压缩已被当前快照覆盖的cmd，保留快照之前的retain_cmd_count个及最近retain_hours小时内的
+func CompactDocumentCmds(documentId string, config *config.Configuration) {
+	conf := &config.CmdCompaction
+	if !conf.Enable {
+		return
+	}
+	mutex := services.GetBus().NewMutex(fmt.Sprintf("%s%s", common.RedisKeyDocumentCmdCompactionMutex, documentId), time.Minute*10)
+	if err := mutex.TryLock(); err != nil {
+		return
+	}
+	defer func() {
+		if _, err := mutex.Unlock(); err != nil {
+			log.Println(documentId, "释放锁失败 cmdCompactionMutex.Unlock", err)
+		}
+	}()
+
+	documentInfo, err := GetDocumentBasicInfoById(documentId)
+	if err != nil {
+		log.Println("cmd压缩，获取文档信息失败", documentId, err)
+		return
+	}
+	retainCmdCount := uint(max(conf.RetainCmdCount, 0))
+	if documentInfo.LastCmdId <= retainCmdCount {
+		return
+	}
+	mode := conf.Mode
+	if mode == "" {
+		mode = models.CmdCompactionArchive
+	}
+	verEnd := documentInfo.LastCmdId - retainCmdCount
+	if mode == models.CmdCompactionDelete {
+		// 固定的版本需要从其快照回放之后的cmd，删除模式下保留这部分；归档模式下仍可从归档读取
+		pinnedVer, err := getPinnedVersionLastCmdVerId(documentId)
+		if err != nil {
+			log.Println("cmd压缩，查询固定版本失败", documentId, err)
+			return
+		}
+		if pinnedVer.Valid {
+			verEnd = min(verEnd, uint(pinnedVer.Int64))
+		}
+	}
+	before := time.Now().Add(-time.Hour * time.Duration(conf.RetainHours))
+	count, err := services.GetCmdService().CompactCmds(documentId, verEnd, before, mode)
+	if err != nil {
+		log.Println("cmd压缩失败", documentId, err)
+	}
+	if count > 0 {
+		log.Println("cmd压缩", documentId, mode, count)
+	}
+}
+
+// 文档中最早的固定版本的最后一个cmd，没有固定版本时无效
+func getPinnedVersionLastCmdVerId(documentId string) (sql.NullInt64, error) {
+	var lastCmdVerId sql.NullInt64
+	err := services.GetDBModule().DB.Model(&models.DocumentVersion{}).
+		Where("document_id = ? and pinned = ?", documentId, true).
+		Select("min(last_cmd_ver_id)").Scan(&lastCmdVerId).Error
+	return lastCmdVerId, err
+}
+
+// 定期压缩最近生成过快照的文档，快照生成时cmd可能还在保留时间内
+func RunCmdCompaction(config *config.Configuration) {
+	conf := &config.CmdCompaction
+	if !conf.Enable {
+		return
+	}
+	interval := time.Second * time.Duration(conf.Interval)
+	if interval <= 0 {
+		interval = defaultCmdCompactionInterval
+	}
+	retain := time.Hour * time.Duration(conf.RetainHours)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var documentIds []string
+		err := services.GetDBModule().DB.Model(&models.DocumentVersion{}).
+			Where("created_at > ?", time.Now().Add(-retain-interval*2)).
+			Distinct("document_id").Pluck("document_id", &documentIds).Error
+		if err != nil {
+			log.Println("cmd压缩，查询文档失败", err)
+			continue
+		}
+		for _, documentId := range documentIds {
+			CompactDocumentCmds(documentId, config)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	com "kcaitech.com/kcserver/common"
+	"kcaitech.com/kcserver/models"
+	safereviewBase "kcaitech.com/kcserver/providers/safereview"
+	"kcaitech.com/kcserver/services"
+	myTime "kcaitech.com/kcserver/utils/time"
+)
+
+// 评论的增删改，http接口和ws共用，返回的int为http状态码
+
+var (
+	errCommentNoPermission = errors.New("无权限")
+	errCommentIdConflict   = errors.New("评论id已存在")
+)
+
+func publishComment(documentId string, publishData *models.UserCommentPublishData) {
+	if data, err := json.Marshal(publishData); err == nil {
+		services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", com.RedisKeyDocumentComment, documentId), data)
+	}
+}
+
+func getCommentWithPermission(userId string, commentId string, documentId string, expectPermType models.PermType) (*models.UserComment, int, error) {
+	commentSrv := services.GetUserCommentService()
+	comment, err := commentSrv.GetComment(documentId, commentId)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	var permType models.PermType
+	if err := services.NewDocumentService().GetPermTypeByDocumentAndUserId(&permType, documentId, userId); err != nil || permType < expectPermType {
+		return nil, http.StatusForbidden, errCommentNoPermission
+	}
+	return comment, 0, nil
+}
+
+// 评论者和文档所有者可以修改、删除评论
+func isCommentManager(comment *models.UserComment, documentOwner string, userId string) bool {
+	return comment.User == userId || documentOwner == userId
+}
+
+func isValidCommentStatus(status models.UserCommentStatus) bool {
+	return status >= models.UserCommentStatusCreated && status <= models.UserCommentStatusResolved
+}
+
+// 已存在同id的评论时，创建者重发返回已有的评论，其他用户不能使用该id
+func checkExistingComment(exist *models.UserComment, userId string) (*models.UserComment, int, error) {
+	if exist.User != userId {
+		return nil, http.StatusConflict, errCommentIdConflict
+	}
+	return exist, 0, nil
+}
+
+func CreateComment(user *models.UserProfile, userComment *models.UserCommentCommon) (*models.UserComment, int, error) {
+	documentId := (userComment.DocumentId)
+	if documentId == "" {
+		return nil, http.StatusBadRequest, errors.New("参数错误：doc_id")
+	}
+	var permType models.PermType
+	if err := services.NewDocumentService().GetPermTypeByDocumentAndUserId(&permType, documentId, user.Id); err != nil || permType < models.PermTypeCommentable {
+		return nil, http.StatusForbidden, errCommentNoPermission
+	}
+
+	commentSrv := services.GetUserCommentService()
+	// 离线队列重发时评论可能已经创建
+	if userComment.CommentId != "" {
+		exist, err := commentSrv.GetComment(documentId, userComment.CommentId)
+		if err == nil {
+			return checkExistingComment(exist, user.Id)
+		}
+		if !errors.Is(err, mongodb.ErrNoDocuments) {
+			log.Println("mongo查询失败", err)
+			return nil, http.StatusInternalServerError, errors.New("评论失败")
+		}
+	}
+
+	_userComment := models.UserComment{
+		UserCommentCommon: models.UserCommentCommon{
+			CommentId:  userComment.CommentId,
+			ParentId:   userComment.ParentId,
+			DocumentId: userComment.DocumentId,
+			PageId:     userComment.PageId,
+			ShapeId:    userComment.ShapeId,
+			Content:    userComment.Content,
+			OffsetX:    userComment.OffsetX,
+			OffsetY:    userComment.OffsetY,
+			RootX:      userComment.RootX,
+			RootY:      userComment.RootY,
+			Status:     models.UserCommentStatusCreated,
+		},
+		User:      user.Id,
+		CreatedAt: myTime.Time(time.Now()).String(),
+	}
+
+	if _, err := myTime.Parse(_userComment.CreatedAt); err != nil {
+		_userComment.RecordCreatedAt = _userComment.CreatedAt
+	}
+
+	reviewClient := services.GetSafereviewClient()
+	if reviewClient != nil {
+		reviewResponse, err := (reviewClient).ReviewText(userComment.Content)
+		if err != nil {
+			log.Println("评论审核失败", userComment.Content, err)
+		} else if reviewResponse != nil && reviewResponse.Status != safereviewBase.ReviewTextResultPass {
+			log.Println("评论审核不通过", userComment.Content, reviewResponse)
+			var LockedWords string
+			if wordsBytes, err := json.Marshal(reviewResponse.Words); err == nil {
+				LockedWords = string(wordsBytes)
+			}
+			services.NewDocumentService().AddLocked(&models.DocumentLock{
+				DocumentId:   documentId,
+				LockedType:   models.LockedTypeComment,
+				LockedReason: reviewResponse.Reason,
+				LockedWords:  LockedWords,
+				LockedTarget: userComment.CommentId,
+			})
+		}
+	}
+
+	if err := commentSrv.InsertOne(&_userComment); err != nil {
+		log.Println("mongo插入失败", err)
+		return nil, http.StatusInternalServerError, errors.New("评论失败")
+	}
+
+	publishComment(documentId, &models.UserCommentPublishData{
+		Type:     models.UserCommentPublishTypeAdd,
+		Comment:  _userComment.UserCommentCommon,
+		User:     *user,
+		CreateAt: _userComment.CreatedAt,
+	})
+	return &_userComment, 0, nil
+}
+
+func UpdateComment(userId string, userComment *models.UserCommentCommon) (int, error) {
+	documentId := (userComment.DocumentId)
+	if documentId == "" {
+		return http.StatusBadRequest, errors.New("参数错误：doc_id")
+	}
+	comment, code, err := getCommentWithPermission(userId, userComment.CommentId, documentId, models.PermTypeCommentable)
+	if err != nil {
+		return code, err
+	}
+	documentService := services.NewDocumentService()
+	var document models.Document
+	if documentService.GetById(documentId, &document) != nil {
+		return http.StatusBadRequest, errors.New("文档不存在")
+	}
+	if !isCommentManager(comment, document.UserId, userId) {
+		return http.StatusForbidden, errCommentNoPermission
+	}
+
+	reviewClient := services.GetSafereviewClient()
+	if userComment.Content != "" && reviewClient != nil {
+		reviewResponse, err := (reviewClient).ReviewText(userComment.Content)
+		if err != nil {
+			log.Println("评论审核失败", userComment.Content, err)
+			return http.StatusBadRequest, errors.New("审核失败")
+		} else if reviewResponse != nil && reviewResponse.Status != safereviewBase.ReviewTextResultPass {
+			log.Println("评论审核不通过", userComment.Content, reviewResponse)
+			return http.StatusBadRequest, errors.New("审核不通过")
+		}
+	}
+
+	commentSrv := services.GetUserCommentService()
+	if err := commentSrv.Update(comment, userComment); err != nil {
+		log.Println("mongo更新失败", err)
+		return http.StatusInternalServerError, errors.New("更新失败")
+	}
+	publishComment(comment.DocumentId, &models.UserCommentPublishData{
+		Type:    models.UserCommentPublishTypeUpdate,
+		Comment: *userComment,
+	})
+	return 0, nil
+}
+
+// 返回删除的数量
+func DeleteComment(userId string, documentId string, commentId string) (int64, int, error) {
+	if documentId == "" {
+		return 0, http.StatusBadRequest, errors.New("参数错误：doc_id")
+	}
+	comment, code, err := getCommentWithPermission(userId, commentId, documentId, models.PermTypeCommentable)
+	if err != nil {
+		return 0, code, err
+	}
+	var document models.Document
+	if services.NewDocumentService().GetById(documentId, &document) != nil {
+		return 0, http.StatusBadRequest, errors.New("文档不存在")
+	}
+	commentSrv := services.GetUserCommentService()
+	// 文档所有者、评论者及被回复的评论者可以删除
+	if !isCommentManager(comment, document.UserId, userId) {
+		if comment.ParentId == "" {
+			return 0, http.StatusForbidden, errCommentNoPermission
+		}
+		parent, err := commentSrv.GetComment(documentId, comment.ParentId)
+		if err != nil {
+			log.Println("文档数据错误1", err)
+			return 0, http.StatusInternalServerError, errors.New("文档数据错误")
+		}
+		if parent.User != (userId) {
+			return 0, http.StatusForbidden, errCommentNoPermission
+		}
+	}
+	delres, err := commentSrv.DeleteOne(comment)
+	if err != nil || delres.DeletedCount <= 0 {
+		log.Println("mongo删除失败", err)
+		return 0, http.StatusInternalServerError, errors.New("删除失败")
+	}
+	publishComment(comment.DocumentId, &models.UserCommentPublishData{
+		Type: models.UserCommentPublishTypeDel,
+		Comment: models.UserCommentCommon{
+			CommentId: commentId,
+			ParentId:  comment.ParentId,
+		},
+	})
+	return delres.DeletedCount, 0, nil
+}
+
+// 解决或重新打开评论
+func SetCommentStatus(userId string, userComment *models.UserCommentSetStatus) (*models.UserCommentCommon, int, error) {
+	documentId := (userComment.DocumentId)
+	if documentId == "" {
+		return nil, http.StatusBadRequest, errors.New("参数错误：doc_id")
+	}
+	if !isValidCommentStatus(userComment.Status) {
+		return nil, http.StatusBadRequest, errors.New("参数错误：status")
+	}
+	comment, code, err := getCommentWithPermission(userId, userComment.Id, documentId, models.PermTypeCommentable)
+	if err != nil {
+		return nil, code, err
+	}
+	if comment.User != (userId) {
+		var count int64
+		if services.NewDocumentService().Count(&count, "id = ? and user_id = ?", comment.DocumentId, userId) != nil || count <= 0 {
+			return nil, http.StatusForbidden, errCommentNoPermission
+		}
+	}
+	commentSrv := services.GetUserCommentService()
+
+	if err := commentSrv.Update(comment, userComment); err != nil {
+		log.Println("mongo更新失败", err)
+		return nil, http.StatusInternalServerError, errors.New("更新失败")
+	}
+
+	comment.Status = userComment.Status
+
+	publishComment(comment.DocumentId, &models.UserCommentPublishData{
+		Type:    models.UserCommentPublishTypeUpdate,
+		Comment: comment.UserCommentCommon,
+	})
+	return &comment.UserCommentCommon, 0, nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"kcaitech.com/kcserver/models"
+)
+
+func TestIsCommentManager(t *testing.T) {
+	comment := &models.UserComment{User: "u1"}
+	cases := []struct {
+		name          string
+		documentOwner string
+		userId        string
+		want          bool
+	}{
+		{"评论者", "owner", "u1", true},
+		{"文档所有者", "owner", "owner", true},
+		{"其他用户", "owner", "u2", false},
+	}
+	for _, c := range cases {
+		if got := isCommentManager(comment, c.documentOwner, c.userId); got != c.want {
+			t.Errorf("%s：期望%v，实际%v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestIsValidCommentStatus(t *testing.T) {
+	cases := []struct {
+		status models.UserCommentStatus
+		want   bool
+	}{
+		{models.UserCommentStatusCreated, true},
+		{models.UserCommentStatusResolved, true},
+		{models.UserCommentStatusResolved + 1, false},
+	}
+	for _, c := range cases {
+		if got := isValidCommentStatus(c.status); got != c.want {
+			t.Errorf("status %d：期望%v，实际%v", c.status, c.want, got)
+		}
+	}
+}
+
+func TestCheckExistingComment(t *testing.T) {
+	exist := &models.UserComment{User: "u1", UserCommentCommon: models.UserCommentCommon{CommentId: "c1"}}
+
+	// 创建者重发返回已有评论
+	comment, code, err := checkExistingComment(exist, "u1")
+	if err != nil || code != 0 || comment != exist {
+		t.Errorf("创建者重发应返回已有评论：%v %d %v", comment, code, err)
+	}
+
+	// 其他用户不能使用已存在的id
+	comment, code, err = checkExistingComment(exist, "u2")
+	if !errors.Is(err, errCommentIdConflict) || code != http.StatusConflict || comment != nil {
+		t.Errorf("其他用户应返回冲突：%v %d %v", comment, code, err)
+	}
+}
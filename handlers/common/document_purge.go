@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"kcaitech.com/kcserver/common"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/providers/storage"
+	"kcaitech.com/kcserver/services"
+)
+
+const (
+	documentPurgeInterval    = time.Minute * 10
+	documentPurgeMaxAttempts = 10
+	documentPurgeBatchSize   = 100
+)
+
+// 记录待清理的文档，需在彻底删除文档记录前调用，已有记录时不重复添加
+func AddDocumentPurge(document *models.Document) error {
+	purgeService := services.NewDocumentPurgeService()
+	purge := models.DocumentPurge{}
+	err := purgeService.Get(&purge, "document_id = ?", document.Id)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, services.ErrRecordNotFound) {
+		return err
+	}
+	return purgeService.Create(&models.DocumentPurge{
+		DocumentId: document.Id,
+		Path:       document.Path,
+	})
+}
+
+// 彻底删除回收站内的文档，并清理其存储及cmds
+func HardDeleteDocument(document *models.Document) error {
+	// 先记录路径，文档记录删除后由清理记录重试
+	if err := AddDocumentPurge(document); err != nil {
+		return err
+	}
+	_, err := services.NewDocumentService().HardDelete("id = ? and deleted_at is not null", document.Id)
+	if err != nil && !errors.Is(err, services.ErrRecordNotFound) {
+		return err
+	}
+	go PurgeDocument(document.Id)
+	return nil
+}
+
+// 删除文档的存储对象（pages、medias、thumbnail、page_image等）、cmds及评论
+// 每一步都可重复执行，失败后记录原因，由RunDocumentPurge重试
+func PurgeDocument(documentId string) error {
+	mutex := services.GetBus().NewMutex(fmt.Sprintf("%s%s", common.RedisKeyDocumentPurgeMutex, documentId), time.Minute*10)
+	if err := mutex.TryLock(); err != nil {
+		return nil
+	}
+	defer func() {
+		if _, err := mutex.Unlock(); err != nil {
+			log.Println(documentId, "释放锁失败 documentPurgeMutex.Unlock", err)
+		}
+	}()
+
+	purgeService := services.NewDocumentPurgeService()
+	purge := models.DocumentPurge{}
+	if err := purgeService.Get(&purge, "document_id = ?", documentId); err != nil {
+		return err
+	}
+	if purge.Status == models.DocumentPurgeStatusDone {
+		return nil
+	}
+	// 文档记录未删除（彻底删除失败）时不能清理
+	var count int64
+	if err := services.NewDocumentService().Count(&count, "id = ?", documentId, &services.Unscoped{}); err != nil {
+		return err
+	}
+	if count > 0 {
+		// 计入重试次数，超过上限后不再轮询
+		if _, err := purgeService.UpdateColumns(map[string]any{
+			"attempts":   services.Expr("attempts + 1"),
+			"last_error": "文档记录未删除",
+		}, "id = ?", purge.Id); err != nil {
+			log.Println("文档清理记录更新失败", documentId, err)
+		}
+		return nil
+	}
+
+	objects, err := purgeDocumentObjects(purge.Path, services.GetStorageClient())
+	var cmds, comments int64
+	if err == nil {
+		cmds, err = services.GetCmdService().DeleteDocumentCmds(documentId)
+	}
+	if err == nil {
+		comments, err = services.GetUserCommentService().DeleteDocumentComments(documentId)
+	}
+
+	values := map[string]any{
+		"attempts": services.Expr("attempts + 1"),
+		"objects":  services.Expr("objects + ?", objects),
+		"cmds":     services.Expr("cmds + ?", cmds),
+		"comments": services.Expr("comments + ?", comments),
+	}
+	if err != nil {
+		log.Println("文档清理失败", documentId, err)
+		lastError := err.Error()
+		if len(lastError) > 1024 {
+			lastError = lastError[:1024]
+		}
+		values["last_error"] = lastError
+	} else {
+		now := time.Now()
+		values["status"] = models.DocumentPurgeStatusDone
+		values["last_error"] = ""
+		values["purged_at"] = &now
+		log.Println("文档清理完成", documentId, objects, cmds, comments)
+	}
+	if _, updateErr := purgeService.UpdateColumns(values, "id = ?", purge.Id); updateErr != nil {
+		log.Println("文档清理记录更新失败", documentId, updateErr)
+	}
+	return err
+}
+
+// 删除path下所有对象的所有版本及删除标记，返回删除的对象版本数，部分删除失败时返回第一个错误
+// bucket开启了多版本，只删除当前版本会留下删除标记，历史版本仍占用存储
+func purgeDocumentObjects(path string, _storage *storage.StorageClient) (int64, error) {
+	// 路径为空时前缀会匹配整个bucket
+	if path == "" {
+		return 0, nil
+	}
+	var deleted int64
+	var firstErr error
+	for object := range _storage.Bucket.ListObjectVersions(path + "/") {
+		if object.Err != nil {
+			if firstErr == nil {
+				firstErr = object.Err
+			}
+			continue
+		}
+		if err := _storage.Bucket.DeleteObjectVersion(object.Key, object.VersionID); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if !object.IsDeleteMarker {
+			deleted++
+		}
+	}
+	if firstErr != nil {
+		return deleted, firstErr
+	}
+	// 列举不完整时不能标记为完成，删除后确认已没有剩余的版本
+	var remainErr error
+	for object := range _storage.Bucket.ListObjectVersions(path + "/") {
+		if remainErr != nil {
+			continue
+		}
+		if object.Err != nil {
+			remainErr = object.Err
+		} else {
+			remainErr = fmt.Errorf("存储对象未清理完：%s %s", object.Key, object.VersionID)
+		}
+	}
+	return deleted, remainErr
+}
+
+// 定期重试未完成的清理
+func RunDocumentPurge() {
+	ticker := time.NewTicker(documentPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		var purges []models.DocumentPurge
+		err := services.NewDocumentPurgeService().Find(
+			&purges,
+			&services.WhereArgs{Query: "status = ? and attempts < ?", Args: []any{models.DocumentPurgeStatusPending, documentPurgeMaxAttempts}},
+			&services.OrderLimitArgs{Order: "id asc", Limit: documentPurgeBatchSize},
+		)
+		if err != nil {
+			log.Println("文档清理，查询记录失败", err)
+			continue
+		}
+		for _, purge := range purges {
+			_ = PurgeDocument(purge.DocumentId)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"kcaitech.com/kcserver/providers/storage"
+)
+
+func TestPurgeDocumentObjects(t *testing.T) {
+	client, err := storage.NewLocalClient(&storage.ClientConfig{RootPath: t.TempDir(), SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_storage := &storage.StorageClient{Client: client, Bucket: client.NewBucket(&storage.BucketConfig{DocumentBucket: "document"})}
+	put := func(name string, content string) {
+		if _, err := _storage.Bucket.PutObject(&storage.PutObjectInput{ObjectName: name, Reader: strings.NewReader(content)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("doc1/document-meta.json", "v1")
+	put("doc1/document-meta.json", "v2")
+	put("doc1/pages/p1", "p1")
+	put("doc10/pages/p1", "other")
+
+	// 历史版本也要删除
+	deleted, err := purgeDocumentObjects("doc1", _storage)
+	if err != nil || deleted != 3 {
+		t.Errorf("期望删除3个版本，实际%d %v", deleted, err)
+	}
+	for object := range _storage.Bucket.ListObjectVersions("doc1/") {
+		t.Errorf("不应有剩余的版本：%+v", object)
+	}
+	if _, err := _storage.Bucket.GetObject("doc10/pages/p1"); err != nil {
+		t.Errorf("前缀相同的其它文档不应被删除：%v", err)
+	}
+	// 路径为空时不删除
+	if deleted, err := purgeDocumentObjects("", _storage); err != nil || deleted != 0 {
+		t.Errorf("路径为空时不应删除：%d %v", deleted, err)
+	}
+}
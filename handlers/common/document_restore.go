@@ -0,0 +1,153 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"kcaitech.com/kcserver/common"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/services"
+)
+
+// 将文档恢复到历史版本
+// 以历史版本的document-meta.json生成新的快照，页面仍引用历史版本的对象
+// 同时写入一条恢复标记cmd，通过op广播通知在线的客户端重新加载
+func RestoreDocumentVersion(userId string, document *models.Document, fromVersion *models.DocumentVersion) (*models.DocumentVersion, error) {
+	documentId := document.Id
+	// 与生成版本互斥，否则生成版本时读取的是恢复前的cmd，写入的快照会覆盖恢复的结果
+	versioningMutex := services.GetBus().NewMutex(fmt.Sprintf("%s%s", common.RedisKeyDocumentVersioningMutex, documentId), time.Minute*2)
+	if err := versioningMutex.TryLock(); err != nil {
+		return nil, ErrVersioningBusy
+	}
+	defer func() {
+		if _, err := versioningMutex.Unlock(); err != nil {
+			log.Println("释放锁失败 documentVersioningMutex.Unlock", err)
+		}
+	}()
+	// 与提交cmd互斥，保证标记之后的cmd都基于恢复后的快照
+	mutex := services.GetBus().NewMutex(fmt.Sprintf("%s%s", common.RedisKeyDocumentOpMutex, documentId), time.Second*10)
+	if err := mutex.Lock(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, err := mutex.Unlock(); err != nil {
+			log.Println("释放锁失败 documentOpMutex.Unlock", err)
+		}
+	}()
+
+	_storage := services.GetStorageClient()
+	documentMetaPath := document.Path + "/document-meta.json"
+	documentMetaBytes, err := _storage.Bucket.GetObjectVersion(documentMetaPath, fromVersion.VersionId)
+	if err != nil {
+		return nil, errors.New("获取历史版本失败 " + err.Error())
+	}
+	documentMeta, err := decodeJsonObject(documentMetaBytes)
+	if err != nil {
+		return nil, errors.New("历史版本格式错误 " + err.Error())
+	}
+
+	cmdService := services.GetCmdService()
+	verId, err := cmdService.AllocVerIds(documentId, 1)
+	if err != nil {
+		return nil, err
+	}
+	releaseVerId := func() {
+		if released, err := cmdService.ReleaseVerIds(documentId, verId, 1); err != nil || !released {
+			log.Println("归还版本号失败", documentId, verId, err)
+		}
+	}
+	documentMeta["lastCmdVer"] = verId
+	documentMetaBytes, err = json.Marshal(documentMeta)
+	if err != nil {
+		releaseVerId()
+		return nil, err
+	}
+	putObjectResult, err := compressPutObjectByte(documentMetaPath, documentMetaBytes, _storage)
+	if err != nil {
+		releaseVerId()
+		return nil, errors.New("对象上传错误 " + err.Error())
+	}
+	// 之后的步骤失败时删除写入的版本，使当前对象回到documents.version_id对应的版本
+	removeObject := func() {
+		if putObjectResult.VersionID == "" {
+			log.Println("对象没有版本号，无法撤销", documentId, documentMetaPath)
+			return
+		}
+		if err := _storage.Bucket.DeleteObjectVersion(documentMetaPath, putObjectResult.VersionID); err != nil {
+			log.Println("撤销恢复的快照失败", documentId, putObjectResult.VersionID, err)
+		}
+	}
+
+	cmdItem := models.CmdItem{
+		DocumentId:   documentId,
+		UserId:       userId,
+		VerId:        verId,
+		BatchStartId: verId,
+		BatchLength:  1,
+		Cmd:          models.NewRestoreCmd(verId-1, putObjectResult.VersionID, fromVersion.VersionId),
+	}
+	if _, err := cmdService.SaveCmdItems([]models.CmdItem{cmdItem}); err != nil {
+		removeObject()
+		releaseVerId()
+		return nil, err
+	}
+	// 之后的步骤失败时撤销标记，否则标记会一直留在待生成版本的cmd中
+	removeMarker := func() {
+		if err := cmdService.DeleteCmdItem(documentId, verId); err != nil {
+			log.Println("撤销恢复标记失败", documentId, verId, err)
+			return
+		}
+		releaseVerId()
+	}
+
+	documentService := services.NewDocumentService()
+	documentVersion := models.DocumentVersion{
+		DocumentId:   documentId,
+		VersionId:    putObjectResult.VersionID,
+		LastCmdVerId: verId,
+	}
+	if err := documentService.DocumentVersionService.Create(&documentVersion); err != nil {
+		removeObject()
+		removeMarker()
+		return nil, err
+	}
+	if _, err := documentService.UpdateColumnsById(documentId, map[string]any{
+		"version_id": putObjectResult.VersionID,
+	}); err != nil {
+		if _, err := documentService.DocumentVersionService.HardDeleteById(documentVersion.Id); err != nil {
+			log.Println("撤销恢复版本失败", documentId, documentVersion.Id, err)
+		}
+		removeObject()
+		removeMarker()
+		return nil, err
+	}
+
+	if cmdItemListData, err := json.Marshal([]models.CmdItem{cmdItem}); err == nil {
+		if err := services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentOp, documentId), cmdItemListData); err != nil {
+			log.Println("cmd广播失败", documentId, err)
+		}
+	}
+	if publishData, err := json.Marshal(&models.DocumentVersionWSData{
+		DocumentId:       documentId,
+		VersionId:        putObjectResult.VersionID,
+		VersionStartWith: verId + 1,
+	}); err == nil {
+		services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentVersion, documentId), publishData)
+	}
+	log.Println("document restored", documentId, fromVersion.VersionId, putObjectResult.VersionID)
+	return &documentVersion, nil
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"kcaitech.com/kcserver/common"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/services"
+)
+
+// 文档权限变更通知，已打开文档的连接收到后重新校验权限
+type DocumentPermissionChange struct {
+	UserId string `json:"user_id,omitempty"` // 为空时所有用户都需要重新校验
+}
+
+func PublishDocumentPermissionChange(documentId string, userId string) {
+	data, err := json.Marshal(&DocumentPermissionChange{UserId: userId})
+	if err != nil {
+		return
+	}
+	if err := services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentPermission, documentId), data); err != nil {
+		log.Println("权限变更通知失败", documentId, userId, err)
+	}
+}
+
+// 项目权限变化时通知项目下的所有文档
+func PublishProjectPermissionChange(projectId string, userId string) {
+	PublishDocumentsPermissionChange(QueryDocumentIds("project_id = ?", projectId), userId)
+}
+
+func PublishDocumentsPermissionChange(documentIds []string, userId string) {
+	for _, documentId := range documentIds {
+		PublishDocumentPermissionChange(documentId, userId)
+	}
+}
+
+// 查询需要通知的文档，删除文档时需在删除前查询
+func QueryDocumentIds(query string, args ...any) []string {
+	var documentIds []string
+	if err := services.GetDBModule().DB.Model(&models.Document{}).
+		Where(query, args...).
+		Pluck("id", &documentIds).Error; err != nil {
+		log.Println("查询文档失败", query, args, err)
+		return nil
+	}
+	return documentIds
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"log"
+	"time"
+
+	config "kcaitech.com/kcserver/config"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/services"
+)
+
+const (
+	defaultRecycleBinInterval = time.Hour
+	recycleBinBatchSize       = 100
+)
+
+func recycleBinRetention(config *config.Configuration) time.Duration {
+	return time.Hour * 24 * time.Duration(config.RecycleBin.RetentionDays)
+}
+
+// 回收站内文档的到期时间，未开启自动删除时返回nil
+func RecycleBinExpiresAt(deletedAt time.Time, config *config.Configuration) *time.Time {
+	retention := recycleBinRetention(config)
+	if retention <= 0 || deletedAt.IsZero() {
+		return nil
+	}
+	expiresAt := deletedAt.Add(retention)
+	return &expiresAt
+}
+
+// 定期彻底删除回收站内超过保留期的文档
+func RunRecycleBinExpiry(config *config.Configuration) {
+	retention := recycleBinRetention(config)
+	if retention <= 0 {
+		return
+	}
+	interval := time.Second * time.Duration(config.RecycleBin.Interval)
+	if interval <= 0 {
+		interval = defaultRecycleBinInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		for {
+			var documents []models.Document
+			err := services.NewDocumentService().Find(
+				&documents,
+				&services.WhereArgs{Query: "deleted_at is not null and deleted_at < ?", Args: []any{time.Now().Add(-retention)}},
+				&services.OrderLimitArgs{Order: "deleted_at asc", Limit: recycleBinBatchSize},
+				&services.Unscoped{},
+			)
+			if err != nil {
+				log.Println("回收站过期清理，查询文档失败", err)
+				break
+			}
+			failed := 0
+			for i := range documents {
+				if err := HardDeleteDocument(&documents[i]); err != nil {
+					log.Println("回收站过期清理，删除文档失败", documents[i].Id, err)
+					failed++
+				}
+			}
+			// 全部失败时等下次再试，避免重复查询到同一批
+			if len(documents) < recycleBinBatchSize || failed == len(documents) {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/services"
+)
+
+// 解析存储中的json数据（document-meta.json、页面），上传时可能经过gzip压缩
+func decodeJsonObject(data []byte) (map[string]any, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+	documentMeta := map[string]any{}
+	if err := json.Unmarshal(data, &documentMeta); err != nil {
+		return nil, err
+	}
+	return documentMeta, nil
+}
+
+// 在服务端将cmds应用到当前快照，生成新版本的数据
+// 存在无法处理的op时返回错误，由调用方交给version server处理
+func generateVersion(documentInfo *DocumentInfo, cmdItemList []models.CmdItem) (*VersionResp, error) {
+	_storage := services.GetStorageClient()
+	documentMetaBytes, err := _storage.Bucket.GetObjectVersion(documentInfo.Path+"/document-meta.json", documentInfo.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	documentMeta, err := decodeJsonObject(documentMetaBytes)
+	if err != nil {
+		return nil, err
+	}
+	pagesList, ok := documentMeta["pagesList"].([]any)
+	if !ok {
+		return nil, errors.New("document-meta.json缺少pagesList")
+	}
+
+	// 并发获取当前快照的所有页面
+	pageIds := make([]string, len(pagesList))
+	pages := make([]map[string]any, len(pagesList))
+	errs := make([]error, len(pagesList))
+	waitGroup := sync.WaitGroup{}
+	for i, page := range pagesList {
+		pageItem, _ := page.(map[string]any)
+		pageId, _ := pageItem["id"].(string)
+		versionId, _ := pageItem["versionId"].(string)
+		if pageId == "" {
+			return nil, errors.New("pagesList内有元素缺少id")
+		}
+		pageIds[i] = pageId
+		waitGroup.Add(1)
+		go func(i int, pageId string, versionId string) {
+			defer waitGroup.Done()
+			pageBytes, err := _storage.Bucket.GetObjectVersion(documentInfo.Path+"/pages/"+pageId+".json", versionId)
+			if err == nil {
+				pages[i], err = decodeJsonObject(pageBytes)
+			}
+			errs[i] = err
+		}(i, pageId, versionId)
+	}
+	waitGroup.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	documentData := models.DocumentData{
+		Meta:  documentMeta,
+		Pages: make(map[string]map[string]any, len(pages)),
+	}
+	for i, pageId := range pageIds {
+		documentData.Pages[pageId] = pages[i]
+	}
+	if err := documentData.ApplyCmds(cmdItemList); err != nil {
+		return nil, err
+	}
+	pagesData, err := json.Marshal(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	// 版本更新不会修改medias，只需要统计
+	mediaNames := make([]string, 0)
+	mediasSize := uint64(0)
+	mediasPrefix := documentInfo.Path + "/medias/"
+	for object := range _storage.Bucket.ListObjects(mediasPrefix) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		mediaNames = append(mediaNames, strings.TrimPrefix(object.Key, mediasPrefix))
+		mediasSize += uint64(object.Size)
+	}
+
+	return &VersionResp{
+		LastCmdVerId: strconv.FormatUint(uint64(cmdItemList[len(cmdItemList)-1].VerId), 10),
+		DocumentData: ExFromJson{
+			DocumentMeta: documentMeta,
+			Pages:        pagesData,
+			MediaNames:   mediaNames,
+		},
+		MediasSize: mediasSize,
+	}, nil
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package common
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/providers/storage"
+	"kcaitech.com/kcserver/services"
+)
+
+const (
+	// 最近仍有写入的目录可能是正在上传的文档，不视为孤立
+	reconcileOrphanGrace     = time.Hour * 24
+	reconcileDocumentBatch   = 500
+	reconcileMaxOrphanReport = 1000
+)
+
+// 对象数及大小都包含历史版本，即实际占用的存储，删除标记不计入
+type StorageReconcileResult struct {
+	Objects        int64    `json:"objects"`         // 扫描的对象版本数
+	Bytes          int64    `json:"bytes"`           // 扫描的对象版本总大小
+	OrphanPrefixes []string `json:"orphan_prefixes"` // 没有对应文档的目录，最多列出reconcileMaxOrphanReport个
+	OrphanObjects  int64    `json:"orphan_objects"`
+	OrphanBytes    int64    `json:"orphan_bytes"`
+	DeletedObjects int64    `json:"deleted_objects"`
+	Documents      int64    `json:"documents"`       // 检查的文档数（含回收站内的）
+	SizeMismatches int64    `json:"size_mismatches"` // Size与存储不一致的文档数
+	SizeFixed      int64    `json:"size_fixed"`
+}
+
+type reconcilePrefix struct {
+	objects      int64
+	size         int64
+	lastModified time.Time
+	hasDocument  bool
+}
+
+// 对账文档存储：找出没有对应文档记录的目录，并按存储重新计算各文档的Size
+// Size为文档目录下所有对象版本（含历史版本）的总大小，与上传时累加的语义一致
+// fix为false时只输出结果，为true时删除孤立对象的所有版本并修正Size
+// 扫描期间的写入可能导致Size不准确，建议在低峰期执行
+func ReconcileStorage(fix bool) (*StorageReconcileResult, error) {
+	_storage := services.GetStorageClient()
+	result := &StorageReconcileResult{OrphanPrefixes: []string{}}
+
+	prefixes, err := scanDocumentPrefixes(_storage, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// 按id分批遍历所有文档，回收站内的文档存储仍需保留
+	db := services.GetDBModule().DB
+	lastId := ""
+	for {
+		var documents []models.Document
+		err := db.Unscoped().Model(&models.Document{}).
+			Select("id", "path", "size").
+			Where("id > ?", lastId).
+			Order("id asc").Limit(reconcileDocumentBatch).
+			Find(&documents).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, document := range documents {
+			result.Documents++
+			size := uint64(0)
+			if prefix, ok := prefixes[document.Path]; ok && document.Path != "" {
+				prefix.hasDocument = true
+				size = uint64(prefix.size)
+			}
+			if document.Size == size {
+				continue
+			}
+			result.SizeMismatches++
+			log.Println("文档大小不一致", document.Id, document.Size, size)
+			if !fix {
+				continue
+			}
+			if err := db.Unscoped().Model(&models.Document{}).Where("id = ?", document.Id).UpdateColumn("size", size).Error; err != nil {
+				log.Println("修正文档大小失败", document.Id, err)
+				continue
+			}
+			result.SizeFixed++
+		}
+		if len(documents) < reconcileDocumentBatch {
+			break
+		}
+		lastId = documents[len(documents)-1].Id
+	}
+
+	for name, prefix := range prefixes {
+		if prefix.hasDocument || time.Since(prefix.lastModified) < reconcileOrphanGrace {
+			continue
+		}
+		result.OrphanObjects += prefix.objects
+		result.OrphanBytes += prefix.size
+		if len(result.OrphanPrefixes) < reconcileMaxOrphanReport {
+			result.OrphanPrefixes = append(result.OrphanPrefixes, name)
+		}
+		if fix {
+			deleted, err := purgeDocumentObjects(name, _storage)
+			if err != nil {
+				log.Println("删除孤立对象失败", name, err)
+			}
+			result.DeletedObjects += deleted
+		}
+	}
+	return result, nil
+}
+
+// 按顶层目录（即文档的Path）统计对象版本数及大小
+func scanDocumentPrefixes(_storage *storage.StorageClient, result *StorageReconcileResult) (map[string]*reconcilePrefix, error) {
+	prefixes := map[string]*reconcilePrefix{}
+	var firstErr error
+	for object := range _storage.Bucket.ListObjectVersions("") {
+		// 出错后继续读完，避免列举协程阻塞
+		if object.Err != nil || firstErr != nil {
+			if firstErr == nil {
+				firstErr = object.Err
+			}
+			continue
+		}
+		// 只有删除标记的目录仍需清理，但不计入大小
+		if object.IsDeleteMarker {
+			object.Size = 0
+		} else {
+			result.Objects++
+		}
+		result.Bytes += object.Size
+		name, _, found := strings.Cut(object.Key, "/")
+		if !found || name == "" {
+			log.Println("不在文档目录下的对象", object.Key)
+			continue
+		}
+		prefix, ok := prefixes[name]
+		if !ok {
+			prefix = &reconcilePrefix{}
+			prefixes[name] = prefix
+		}
+		if !object.IsDeleteMarker {
+			prefix.objects++
+		}
+		prefix.size += object.Size
+		if object.LastModified.After(prefix.lastModified) {
+			prefix.lastModified = object.LastModified
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return prefixes, nil
+}
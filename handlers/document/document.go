@@ -142,6 +142,7 @@ func DeleteUserDocument(c *gin.Context) {
 			log.Println("更新文档删除者失败", err.Error())
 		}
 	}
+	go common.PublishDocumentPermissionChange(documentId, "")
 	common.Success(c, "")
 }
 
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package document
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kcaitech.com/kcserver/handlers/common"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/providers/storage"
+	"kcaitech.com/kcserver/services"
+	"kcaitech.com/kcserver/utils"
+)
+
+const documentObjectMaxListCount = 1000
+
+type DocumentObjectItem struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// 对象名规范化，不允许跳出文档目录
+func cleanDocumentObjectKey(key string) string {
+	return strings.TrimLeft(path.Clean("/"+key), "/")
+}
+
+// GetDocumentObject 代理读取文档对象，权限与获取文档密钥一致
+// GET/HEAD /documents/objects/<key>?doc_id=&versionId= 支持Range及ETag协商缓存
+// GET /documents/objects/?doc_id=&prefix= 列举文档目录下的对象
+func GetDocumentObject(c *gin.Context) {
+	if !services.GetConfig().StorageProxy.Enable {
+		common.Resp(c, http.StatusNotFound, "未开启存储代理", nil)
+		return
+	}
+	userId, err := utils.GetUserId(c)
+	if err != nil {
+		common.Unauthorized(c)
+		return
+	}
+	documentId := c.Query("doc_id")
+	if documentId == "" {
+		common.BadRequest(c, "参数错误：doc_id")
+		return
+	}
+	document := checkDocumentPerm(c, userId, documentId, models.PermTypeReadOnly)
+	if document == nil {
+		return
+	}
+	documentPrefix := document.Path + "/"
+
+	key := c.Param("key")
+	if key == "" || key == "/" {
+		listDocumentObjects(c, documentPrefix)
+		return
+	}
+	key = cleanDocumentObjectKey(key)
+	if !strings.HasPrefix(key, documentPrefix) {
+		common.Forbidden(c, "")
+		return
+	}
+	serveDocumentObject(c, key, c.Query("versionId"))
+}
+
+func listDocumentObjects(c *gin.Context, documentPrefix string) {
+	prefix := documentPrefix
+	if p := c.Query("prefix"); p != "" {
+		prefix = strings.TrimLeft(p, "/") // 保留末尾的/
+		if strings.Contains(prefix, "..") || !strings.HasPrefix(prefix, documentPrefix) {
+			common.Forbidden(c, "")
+			return
+		}
+	}
+	items := make([]DocumentObjectItem, 0)
+	for object := range services.GetStorageClient().Bucket.ListObjects(prefix) {
+		// 读完剩余的对象，避免列举协程阻塞
+		if object.Err != nil || len(items) >= documentObjectMaxListCount {
+			continue
+		}
+		items = append(items, DocumentObjectItem{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+	}
+	common.Success(c, items)
+}
+
+func etagMatch(ifNoneMatch string, etag string) bool {
+	for _, item := range strings.Split(ifNoneMatch, ",") {
+		item = strings.TrimPrefix(strings.TrimSpace(item), "W/")
+		if item == etag || item == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func serveDocumentObject(c *gin.Context, key string, versionId string) {
+	// 对象信息与内容来自同一次读取，内容按需流式读取
+	reader, err := storage.NewObjectReader(services.GetStorageClient().Bucket, key, versionId)
+	if err != nil {
+		log.Println("读取对象失败", key, versionId, err)
+		common.Resp(c, http.StatusNotFound, "对象不存在", nil)
+		return
+	}
+	defer reader.Close()
+	info := reader.Info()
+	header := c.Writer.Header()
+
+	var modTime time.Time
+	if versionId != "" {
+		// 历史版本不会再变化
+		header.Set("ETag", strconv.Quote("v-"+versionId))
+		header.Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		etag := info.ETag
+		if etag == "" {
+			etag = info.VersionID
+		}
+		if etag != "" {
+			header.Set("ETag", strconv.Quote(etag))
+		}
+		if maxAge := services.GetConfig().StorageProxy.MaxAge; maxAge > 0 {
+			header.Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+		} else {
+			header.Set("Cache-Control", "private, no-cache")
+		}
+		modTime = info.LastModified
+	}
+	// 命中缓存时不读取内容
+	if etag := header.Get("ETag"); etag != "" && etagMatch(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	http.ServeContent(c.Writer, c.Request, path.Base(key), modTime, reader)
+}
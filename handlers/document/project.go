@@ -341,11 +341,13 @@ func DeleteProject(c *gin.Context) {
 		return
 	}
 	// 删除项目文档
+	documentIds := common.QueryDocumentIds("project_id = ?", projectId)
 	documentService := services.NewDocumentService()
 	if _, err := documentService.Delete("project_id = ?", projectId); err != nil && !errors.Is(err, services.ErrRecordNotFound) {
 		common.ServerError(c, "项目文档删除失败")
 		return
 	}
+	go common.PublishDocumentsPermissionChange(documentIds, "")
 	common.Success(c, "")
 }
 
@@ -801,6 +803,10 @@ func SetProjectInvited(c *gin.Context) {
 		common.ServerError(c, "更新错误")
 		return
 	}
+	// 公开权限变化时，项目文档的权限需要重新校验
+	if req.IsPublic != nil || req.PermType != nil {
+		go common.PublishProjectPermissionChange(projectId, "")
+	}
 	common.Success(c, "")
 }
 
@@ -934,6 +940,7 @@ func SetProjectMemberPermission(c *gin.Context) {
 		common.ServerError(c, "更新错误")
 		return
 	}
+	go common.PublishProjectPermissionChange(projectId, reqUserId)
 	common.Success(c, "")
 }
 
@@ -1051,6 +1058,7 @@ func RemoveProjectMember(c *gin.Context) {
 		common.ServerError(c, "团队成员删除失败")
 		return
 	}
+	go common.PublishProjectPermissionChange(projectId, reqUserId)
 	common.Success(c, "")
 }
 
@@ -1179,6 +1187,8 @@ func MoveDocument(c *gin.Context) {
 		common.ServerError(c, "更新错误")
 		return
 	}
+	// 权限随所属项目变化
+	go common.PublishDocumentPermissionChange(documentId, "")
 
 	common.Success(c, "")
 }
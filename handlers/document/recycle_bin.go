@@ -12,6 +12,7 @@ package document
 
 import (
 	"errors"
+	"log"
 	"net/http"
 	"time"
 
@@ -78,6 +79,7 @@ func GetUserRecycleBinDocumentList(c *gin.Context) {
 				}
 			}
 		}
+		item.ExpiresAt = common.RecycleBinExpiresAt(item.Document.DeletedAt.Time, services.GetConfig())
 		if exists {
 			item.User = &models.UserProfile{
 				Id:       userInfo.UserID,
@@ -180,11 +182,10 @@ func DeleteUserRecycleBinDocument(c *gin.Context) {
 			return
 		}
 	}
-	_, err = documentService.HardDelete("id = ? and deleted_at is not null", documentId)
-	if err != nil && !errors.Is(err, services.ErrRecordNotFound) {
+	if err := common.HardDeleteDocument(&document); err != nil {
+		log.Println("彻底删除文档失败", documentId, err)
 		common.ServerError(c, "更新错误")
 		return
 	}
-	// todo 删除oss文件
 	common.Success(c, "")
 }
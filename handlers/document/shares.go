@@ -175,6 +175,8 @@ func SetDocumentShareType(c *gin.Context) {
 			// return
 		}
 	}
+	// 分享类型影响所有非成员用户的权限
+	common.PublishDocumentPermissionChange(documentId, "")
 	common.Success(c, "")
 }
 
@@ -309,6 +311,7 @@ func SetDocumentSharePermission(c *gin.Context) {
 		"id = ?",
 		permissionId,
 	)
+	common.PublishDocumentPermissionChange(documentPermission.DocumentPermission.ResourceId, documentPermission.DocumentPermission.GranteeId)
 	common.Success(c, "")
 }
 
@@ -338,7 +341,12 @@ func DeleteDocumentSharePermission(c *gin.Context) {
 		}
 		return
 	}
+	documentPermission := models.DocumentPermission{}
+	_ = documentPermissionService.GetById(permissionId, &documentPermission)
 	_, _ = services.NewDocumentService().DocumentPermissionService.HardDelete("id = ?", permissionId)
+	if documentPermission.ResourceId != "" {
+		common.PublishDocumentPermissionChange(documentPermission.ResourceId, documentPermission.GranteeId)
+	}
 	common.Success(c, "")
 }
 
@@ -565,6 +573,7 @@ func ReviewDocumentPermissionRequest(c *gin.Context) {
 				}
 			}
 		}
+		common.PublishDocumentPermissionChange(documentPermissionRequest.DocumentId, documentPermissionRequest.UserId)
 	}
 	common.Success(c, "")
 }
@@ -342,6 +342,7 @@ func DeleteTeam(c *gin.Context) {
 		return
 	}
 	// 删除团队文档
+	documentIds := common.QueryDocumentIds("team_id = ?", teamId)
 	documentService := services.NewDocumentService()
 	if _, err := documentService.Delete("team_id = ?", teamId); err != nil && !errors.Is(err, services.ErrRecordNotFound) {
 		log.Println("团队文档删除失败", err)
@@ -353,6 +354,7 @@ func DeleteTeam(c *gin.Context) {
 		common.ServerError(c, "团队删除失败")
 		return
 	}
+	go common.PublishDocumentsPermissionChange(documentIds, "")
 	common.Success(c, "")
 }
 
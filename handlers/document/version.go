@@ -0,0 +1,338 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package document
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"kcaitech.com/kcserver/handlers/common"
+	"kcaitech.com/kcserver/models"
+	safereviewBase "kcaitech.com/kcserver/providers/safereview"
+	"kcaitech.com/kcserver/providers/storage"
+	"kcaitech.com/kcserver/services"
+	"kcaitech.com/kcserver/utils"
+)
+
+const (
+	maxVersionNameLength        = 128
+	maxVersionDescriptionLength = 1024
+)
+
+// 校验文档权限，返回文档
+func checkDocumentPerm(c *gin.Context, userId string, documentId string, needPermType models.PermType) *models.Document {
+	documentService := services.NewDocumentService()
+	document := models.Document{}
+	if documentService.GetById(documentId, &document) != nil {
+		common.BadRequest(c, "文档不存在")
+		return nil
+	}
+	var permType models.PermType
+	if err := documentService.GetPermTypeByDocumentAndUserId(&permType, documentId, userId); err != nil || permType < needPermType {
+		common.Forbidden(c, "")
+		return nil
+	}
+	locked, _ := documentService.GetLocked(documentId)
+	if len(locked) > 0 && document.UserId != userId {
+		common.ReviewFail(c, "审核不通过")
+		return nil
+	}
+	return &document
+}
+
+func getDocumentVersion(c *gin.Context, documentId string, versionId string) *models.DocumentVersion {
+	documentVersion := models.DocumentVersion{}
+	if err := services.NewDocumentVersionService().Get(&documentVersion, "document_id = ? and version_id = ?", documentId, versionId); err != nil {
+		common.BadRequest(c, "版本不存在")
+		return nil
+	}
+	return &documentVersion
+}
+
+// GetDocumentVersionList 获取文档版本列表
+func GetDocumentVersionList(c *gin.Context) {
+	userId, err := utils.GetUserId(c)
+	if err != nil {
+		common.Unauthorized(c)
+		return
+	}
+	documentId := c.Query("doc_id")
+	if documentId == "" {
+		common.BadRequest(c, "参数错误：doc_id")
+		return
+	}
+	if checkDocumentPerm(c, userId, documentId, models.PermTypeReadOnly) == nil {
+		return
+	}
+
+	cursor := c.Query("cursor")
+	limit := utils.QueryInt(c, "limit", 20) // 默认每页20条
+	namedOnly := c.Query("named") == "true"
+
+	versionList, hasMore := services.NewDocumentVersionService().FindByDocumentIdWithCursor(documentId, namedOnly, cursor, limit)
+
+	var nextCursor string
+	if hasMore && len(*versionList) > 0 {
+		nextCursor = strconv.FormatInt((*versionList)[len(*versionList)-1].Id, 10)
+	}
+	common.SuccessWithCursor(c, versionList, hasMore, nextCursor)
+}
+
+type SetDocumentVersionInfoReq struct {
+	DocId       string  `json:"doc_id" binding:"required"`
+	VersionId   string  `json:"version_id" binding:"required"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// SetDocumentVersionInfo 设置版本名称及描述
+func SetDocumentVersionInfo(c *gin.Context) {
+	userId, err := utils.GetUserId(c)
+	if err != nil {
+		common.Unauthorized(c)
+		return
+	}
+	var req SetDocumentVersionInfoReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "")
+		return
+	}
+	if req.Name == nil && req.Description == nil {
+		common.BadRequest(c, "参数错误：name或description")
+		return
+	}
+	if checkDocumentPerm(c, userId, req.DocId, models.PermTypeEditable) == nil {
+		return
+	}
+	documentVersion := getDocumentVersion(c, req.DocId, req.VersionId)
+	if documentVersion == nil {
+		return
+	}
+
+	values := map[string]any{}
+	reviewText := ""
+	if req.Name != nil {
+		if utf8.RuneCountInString(*req.Name) > maxVersionNameLength {
+			common.BadRequest(c, "版本名称过长")
+			return
+		}
+		values["name"] = *req.Name
+		reviewText += *req.Name
+	}
+	if req.Description != nil {
+		if utf8.RuneCountInString(*req.Description) > maxVersionDescriptionLength {
+			common.BadRequest(c, "版本描述过长")
+			return
+		}
+		values["description"] = *req.Description
+		reviewText += "\n" + *req.Description
+	}
+
+	reviewClient := services.GetSafereviewClient()
+	if reviewClient != nil && reviewText != "" {
+		reviewResponse, err := (reviewClient).ReviewText(reviewText)
+		if err != nil {
+			log.Println("版本信息审核失败", reviewText, err)
+			common.ReviewFail(c, "审核失败")
+			return
+		} else if reviewResponse != nil && reviewResponse.Status != safereviewBase.ReviewTextResultPass {
+			log.Println("版本信息审核不通过", reviewText, reviewResponse)
+			common.ReviewFail(c, "审核不通过")
+			return
+		}
+	}
+
+	if _, err := services.NewDocumentVersionService().UpdateColumnsById(strconv.FormatInt(documentVersion.Id, 10), values); err != nil {
+		log.Println("更新版本信息失败", err)
+		common.ServerError(c, "更新失败")
+		return
+	}
+	common.Success(c, "")
+}
+
+type SetDocumentVersionPinnedReq struct {
+	DocId     string `json:"doc_id" binding:"required"`
+	VersionId string `json:"version_id" binding:"required"`
+	Pinned    bool   `json:"pinned"`
+}
+
+// SetDocumentVersionPinned 固定/取消固定版本
+func SetDocumentVersionPinned(c *gin.Context) {
+	userId, err := utils.GetUserId(c)
+	if err != nil {
+		common.Unauthorized(c)
+		return
+	}
+	var req SetDocumentVersionPinnedReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "")
+		return
+	}
+	if checkDocumentPerm(c, userId, req.DocId, models.PermTypeEditable) == nil {
+		return
+	}
+	documentVersion := getDocumentVersion(c, req.DocId, req.VersionId)
+	if documentVersion == nil {
+		return
+	}
+	if _, err := services.NewDocumentVersionService().UpdateColumnsById(strconv.FormatInt(documentVersion.Id, 10), map[string]any{
+		"pinned": req.Pinned,
+	}); err != nil {
+		log.Println("更新版本固定状态失败", err)
+		common.ServerError(c, "更新失败")
+		return
+	}
+	common.Success(c, "")
+}
+
+type DocumentVersionAccessKeyResp struct {
+	common.AccessKeyInfo
+	Path    string                  `json:"path"`
+	Version *models.DocumentVersion `json:"version"`
+}
+
+// GetDocumentVersionAccessKey 获取历史版本的只读访问密钥
+func GetDocumentVersionAccessKey(c *gin.Context) {
+	userId, err := utils.GetUserId(c)
+	if err != nil {
+		common.Unauthorized(c)
+		return
+	}
+	documentId := c.Query("doc_id")
+	if documentId == "" {
+		common.BadRequest(c, "参数错误：doc_id")
+		return
+	}
+	versionId := c.Query("version_id")
+	if versionId == "" {
+		common.BadRequest(c, "参数错误：version_id")
+		return
+	}
+	document := checkDocumentPerm(c, userId, documentId, models.PermTypeReadOnly)
+	if document == nil {
+		return
+	}
+	documentVersion := getDocumentVersion(c, documentId, versionId)
+	if documentVersion == nil {
+		return
+	}
+
+	if services.GetConfig().StorageProxy.Enable {
+		common.Success(c, &DocumentVersionAccessKeyResp{
+			AccessKeyInfo: *common.StorageProxyAccessKeyInfo(),
+			Path:          document.Path,
+			Version:       documentVersion,
+		})
+		return
+	}
+
+	// 历史版本的document-meta.json及其引用的页面都需要按版本id获取
+	_storage := services.GetStorageClient()
+	accessKeyValue, err := _storage.Bucket.GenerateAccessKey(
+		document.Path+"/*",
+		storage.AuthOpGetObject|storage.AuthOpGetVersion|storage.AuthOpListObject,
+		3600,
+		"U"+(userId)+"D"+(documentId),
+	)
+	if err != nil {
+		log.Println("生成密钥失败", err)
+		common.ServerError(c, "生成密钥失败")
+		return
+	}
+	storageConfig := _storage.Bucket.GetConfig()
+	common.Success(c, &DocumentVersionAccessKeyResp{
+		AccessKeyInfo: common.AccessKeyInfo{
+			AccessKey:       accessKeyValue.AccessKey,
+			SecretAccessKey: accessKeyValue.SecretAccessKey,
+			SessionToken:    accessKeyValue.SessionToken,
+			SignerType:      accessKeyValue.SignerType,
+			Provider:        string(services.GetConfig().Storage.Provider),
+			Region:          storageConfig.Region,
+			BucketName:      storageConfig.DocumentBucket,
+			Endpoint:        services.GetConfig().StorageUrl.Document,
+		},
+		Path:    document.Path,
+		Version: documentVersion,
+	})
+}
+
+type RestoreDocumentVersionReq struct {
+	DocId     string `json:"doc_id" binding:"required"`
+	VersionId string `json:"version_id" binding:"required"`
+}
+
+// RestoreDocumentVersion 将文档恢复到历史版本
+func RestoreDocumentVersion(c *gin.Context) {
+	userId, err := utils.GetUserId(c)
+	if err != nil {
+		common.Unauthorized(c)
+		return
+	}
+	var req RestoreDocumentVersionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "")
+		return
+	}
+	document := checkDocumentPerm(c, userId, req.DocId, models.PermTypeEditable)
+	if document == nil {
+		return
+	}
+	if document.VersionId == req.VersionId {
+		common.BadRequest(c, "已是当前版本")
+		return
+	}
+	fromVersion := getDocumentVersion(c, req.DocId, req.VersionId)
+	if fromVersion == nil {
+		return
+	}
+	documentVersion, err := common.RestoreDocumentVersion(userId, document, fromVersion)
+	if errors.Is(err, common.ErrVersioningBusy) {
+		common.Resp(c, http.StatusConflict, "正在生成版本，请稍后重试", nil)
+		return
+	}
+	if err != nil {
+		log.Println("恢复版本失败", req.DocId, req.VersionId, err)
+		common.ServerError(c, "恢复版本失败")
+		return
+	}
+	common.Success(c, documentVersion)
+}
+
+type CreateDocumentSnapshotReq struct {
+	DocId string `json:"doc_id" binding:"required"`
+}
+
+// CreateDocumentSnapshot 立即生成新版本，由版本调度异步处理
+func CreateDocumentSnapshot(c *gin.Context) {
+	userId, err := utils.GetUserId(c)
+	if err != nil {
+		common.Unauthorized(c)
+		return
+	}
+	var req CreateDocumentSnapshotReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.BadRequest(c, "")
+		return
+	}
+	if checkDocumentPerm(c, userId, req.DocId, models.PermTypeEditable) == nil {
+		return
+	}
+	if err := common.ForceDocumentVersion(req.DocId); err != nil {
+		log.Println("加入版本队列失败", req.DocId, err)
+		common.ServerError(c, "生成版本失败")
+		return
+	}
+	common.Success(c, "")
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package handlers
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"kcaitech.com/kcserver/handlers/common"
+	"kcaitech.com/kcserver/providers/storage"
+	"kcaitech.com/kcserver/services"
+)
+
+const (
+	localStorageMaxPutSize   = 512 << 20
+	localStorageMaxListCount = 1000
+)
+
+type localStorageObject struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// 返回名称对应的本地bucket，attatch bucket公共读，与其它provider中的bucket策略一致
+func getLocalBucket(bucketName string) (*storage.LocalBucket, bool) {
+	_storage := services.GetStorageClient()
+	if bucket, ok := _storage.Bucket.(*storage.LocalBucket); ok && bucket.GetConfig().DocumentBucket == bucketName {
+		return bucket, false
+	}
+	if bucket, ok := _storage.AttatchBucket.(*storage.LocalBucket); ok && bucket.GetConfig().DocumentBucket == bucketName {
+		return bucket, true
+	}
+	return nil, false
+}
+
+func localStorageToken(c *gin.Context) string {
+	if token := c.Query("token"); token != "" {
+		return token
+	}
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token
+}
+
+// LocalStorageObject 本地存储的对象读写，令牌由LocalBucket.GenerateAccessKey生成
+// GET/HEAD /api/storage/:bucket/*key?versionId=&token= 获取对象，key为空时按prefix列举
+// PUT 上传对象，DELETE 删除对象
+func LocalStorageObject(c *gin.Context) {
+	bucketName := c.Param("bucket")
+	objectName := strings.TrimPrefix(c.Param("key"), "/")
+	versionId := c.Query("versionId")
+	bucket, publicRead := getLocalBucket(bucketName)
+	if bucket == nil {
+		common.Resp(c, http.StatusNotFound, "bucket不存在", nil)
+		return
+	}
+
+	var authOp int
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead:
+		if objectName == "" {
+			authOp = storage.AuthOpListObject
+		} else if versionId != "" {
+			authOp = storage.AuthOpGetVersion
+		} else {
+			authOp = storage.AuthOpGetObject
+		}
+	case http.MethodPut:
+		authOp = storage.AuthOpPutObject
+	case http.MethodDelete:
+		authOp = storage.AuthOpDelObject
+	default:
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !publicRead || authOp != storage.AuthOpGetObject {
+		claims, err := bucket.VerifyAccessToken(localStorageToken(c))
+		if err != nil {
+			common.Unauthorized(c)
+			return
+		}
+		var allow bool
+		if authOp == storage.AuthOpListObject {
+			allow = claims.AllowList(bucketName, c.Query("prefix"))
+		} else {
+			allow = claims.Allow(bucketName, objectName, authOp)
+		}
+		if !allow {
+			common.Forbidden(c, "")
+			return
+		}
+	}
+
+	switch authOp {
+	case storage.AuthOpGetObject, storage.AuthOpGetVersion:
+		file, err := bucket.OpenObject(objectName, versionId)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, storage.ErrInvalidObjectName) {
+				common.Resp(c, http.StatusNotFound, "对象不存在", nil)
+				return
+			}
+			log.Println("本地存储读取失败", objectName, err)
+			common.ServerError(c, "读取失败")
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			common.ServerError(c, "读取失败")
+			return
+		}
+		if contentType := mime.TypeByExtension(path.Ext(objectName)); contentType != "" {
+			c.Header("Content-Type", contentType)
+		} else {
+			c.Header("Content-Type", "application/octet-stream")
+		}
+		http.ServeContent(c.Writer, c.Request, path.Base(objectName), stat.ModTime(), file)
+	case storage.AuthOpPutObject:
+		info, err := bucket.PutObject(&storage.PutObjectInput{
+			ObjectName:  objectName,
+			Reader:      http.MaxBytesReader(c.Writer, c.Request.Body, localStorageMaxPutSize),
+			ObjectSize:  c.Request.ContentLength,
+			ContentType: c.ContentType(),
+		})
+		if err != nil {
+			log.Println("本地存储写入失败", objectName, err)
+			common.BadRequest(c, "上传失败")
+			return
+		}
+		common.Success(c, info)
+	case storage.AuthOpDelObject:
+		if err := bucket.DeleteObject(objectName); err != nil {
+			log.Println("本地存储删除失败", objectName, err)
+			common.ServerError(c, "删除失败")
+			return
+		}
+		common.Success(c, "")
+	case storage.AuthOpListObject:
+		objects := make([]localStorageObject, 0)
+		for object := range bucket.ListObjects(c.Query("prefix")) {
+			// 读完剩余的对象，避免列举协程阻塞
+			if object.Err != nil || len(objects) >= localStorageMaxListCount {
+				continue
+			}
+			objects = append(objects, localStorageObject{
+				Key:          object.Key,
+				Size:         object.Size,
+				LastModified: object.LastModified,
+			})
+		}
+		common.Success(c, objects)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"kcaitech.com/kcserver/common"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/providers/bus"
+	"kcaitech.com/kcserver/services"
+	"kcaitech.com/kcserver/utils/websocket"
+)
+
+// 临时广播数据，只转发给文档内的其它连接，不做存储
+type BroadcastKind string
+
+const (
+	BroadcastKindPointer  BroadcastKind = "pointer"  // 鼠标指针
+	BroadcastKindReaction BroadcastKind = "reaction" // 表情回应
+	BroadcastKindChat     BroadcastKind = "chat"     // 文档内聊天
+)
+
+const broadcastMsgTooFrequent = "发送过于频繁"
+
+type broadcastRule struct {
+	maxSize  int             // payload的最大字节数
+	permType models.PermType // 最低权限
+	rate     float64         // 每秒允许的消息数
+	burst    float64
+}
+
+var broadcastRules = map[BroadcastKind]broadcastRule{
+	BroadcastKindPointer:  {maxSize: 256, permType: models.PermTypeReadOnly, rate: 20, burst: 20},
+	BroadcastKindReaction: {maxSize: 256, permType: models.PermTypeReadOnly, rate: 2, burst: 5},
+	BroadcastKindChat:     {maxSize: 4096, permType: models.PermTypeCommentable, rate: 1, burst: 5},
+}
+
+type BroadcastData struct {
+	Kind    BroadcastKind   `json:"kind"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type BroadcastOpData struct {
+	Kind     BroadcastKind   `json:"kind"`
+	UserId   string          `json:"user_id"`
+	SenderId string          `json:"sender_id"` // 发送方连接，用于过滤自己发出的消息
+	Payload  json.RawMessage `json:"payload"`
+	Time     int64           `json:"time"`
+}
+
+// 令牌桶，超出速率的消息直接丢弃
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type broadcastServe struct {
+	ws         *websocket.Ws
+	quit       chan struct{}
+	genSId     func() string
+	documentId string
+	userId     string
+	senderId   string
+	permType   atomic.Uint32 // models.PermType，权限变化时更新
+
+	mutex   sync.Mutex
+	buckets map[BroadcastKind]*tokenBucket
+}
+
+func NewBroadcastServe(ws *websocket.Ws, userId string, documentId string, genSId func() string) *broadcastServe {
+
+	// 权限校验
+	var permType models.PermType
+	if err := services.NewDocumentService().GetPermTypeByDocumentAndUserId(&permType, documentId, userId); err != nil || permType < models.PermTypeReadOnly {
+		log.Println("NO broadcast perm", err, permType)
+		return nil
+	}
+
+	serv := broadcastServe{
+		ws:         ws,
+		quit:       make(chan struct{}),
+		genSId:     genSId,
+		documentId: documentId,
+		userId:     userId,
+		senderId:   uuid.NewString(),
+		buckets:    map[BroadcastKind]*tokenBucket{},
+	}
+	for kind, rule := range broadcastRules {
+		serv.buckets[kind] = newTokenBucket(rule.rate, rule.burst)
+	}
+	serv.permType.Store(uint32(permType))
+	serv.start(documentId)
+	return &serv
+}
+
+func (serv *broadcastServe) start(documentId string) {
+	go func() {
+		subscription, err := bus.SubscribeResumable(context.Background(), services.GetBus().Ephemeral(), fmt.Sprintf("%s%s", common.RedisKeyDocumentBroadcast, documentId), "")
+		if err != nil {
+			log.Println("subscribe fail", err)
+			return
+		}
+		defer subscription.Close()
+		channel := subscription.Channel()
+		for {
+			select {
+			case v, ok := <-channel:
+				if !ok {
+					return
+				}
+				serv.send(v.Payload)
+			case <-serv.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (serv *broadcastServe) setPermType(permType models.PermType) {
+	serv.permType.Store(uint32(permType))
+}
+
+func (serv *broadcastServe) close() {
+	close(serv.quit)
+}
+
+// 校验并限流，返回空字符串表示允许发送
+func (serv *broadcastServe) check(broadcastData *BroadcastData) string {
+	rule, ok := broadcastRules[broadcastData.Kind]
+	if !ok {
+		return "不支持的广播类型"
+	}
+	if len(broadcastData.Payload) == 0 || len(broadcastData.Payload) > rule.maxSize {
+		return "广播数据大小超出限制"
+	}
+	if models.PermType(serv.permType.Load()) < rule.permType {
+		return "无权限"
+	}
+	serv.mutex.Lock()
+	defer serv.mutex.Unlock()
+	if !serv.buckets[broadcastData.Kind].allow(time.Now()) {
+		return broadcastMsgTooFrequent
+	}
+	return ""
+}
+
+// 成功时不回复，避免指针等高频消息增加一倍流量
+func (serv *broadcastServe) handle(data *TransData, binaryData *([]byte)) {
+	serverData := TransData{}
+	serverData.Type = data.Type
+	serverData.DataId = data.DataId
+	serverData.DocId = serv.documentId
+
+	broadcastData := &BroadcastData{}
+	if err := json.Unmarshal([]byte(data.Data), broadcastData); err != nil {
+		log.Println("broadcast数据解码错误", err)
+		serverData.Msg = "broadcast数据解码错误"
+		_ = serv.ws.SendJSON(&serverData)
+		return
+	}
+	if msg := serv.check(broadcastData); msg != "" {
+		// 指针消息被限流时静默丢弃
+		if broadcastData.Kind != BroadcastKindPointer || msg != broadcastMsgTooFrequent {
+			serverData.Msg = msg
+			_ = serv.ws.SendJSON(&serverData)
+		}
+		return
+	}
+
+	opData, err := json.Marshal(&BroadcastOpData{
+		Kind:     broadcastData.Kind,
+		UserId:   serv.userId,
+		SenderId: serv.senderId,
+		Payload:  broadcastData.Payload,
+		Time:     time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Println("broadcast数据编码错误", err)
+		return
+	}
+	// 临时消息不持久化，stream模式下也不写入stream
+	services.GetBus().Ephemeral().Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentBroadcast, serv.documentId), string(opData))
+}
+
+func (serv *broadcastServe) send(data string) {
+	opData := BroadcastOpData{}
+	if err := json.Unmarshal([]byte(data), &opData); err != nil {
+		log.Println("broadcast, data wrong", err)
+		return
+	}
+	if opData.SenderId == serv.senderId {
+		return
+	}
+	serverData := TransData{
+		Type:   DataTypes_Broadcast,
+		DataId: serv.genSId(),
+		Data:   data,
+		DocId:  serv.documentId,
+	}
+	var err error
+	if opData.Kind == BroadcastKindPointer {
+		// 指针只需最新位置
+		err = serv.ws.SendJSONCoalesce(DataTypes_Broadcast+":"+serv.documentId+":"+opData.UserId, &serverData)
+	} else {
+		err = serv.ws.SendJSON(&serverData)
+	}
+	if err != nil {
+		log.Println("broadcast, send data fail", err)
+	}
+}
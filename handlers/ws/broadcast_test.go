@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"kcaitech.com/kcserver/models"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, 3)
+	now := b.last
+	for i := 0; i < 3; i++ {
+		if !b.allow(now) {
+			t.Fatalf("第%d条消息应允许", i)
+		}
+	}
+	if b.allow(now) {
+		t.Error("超出突发数量应拒绝")
+	}
+	if !b.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("补充令牌后应允许")
+	}
+}
+
+func TestBroadcastCheck(t *testing.T) {
+	serv := &broadcastServe{buckets: map[BroadcastKind]*tokenBucket{}}
+	for kind, rule := range broadcastRules {
+		serv.buckets[kind] = newTokenBucket(rule.rate, rule.burst)
+	}
+	serv.permType.Store(uint32(models.PermTypeReadOnly))
+
+	if msg := serv.check(&BroadcastData{Kind: "unknown", Payload: json.RawMessage(`{}`)}); msg == "" {
+		t.Error("未知类型应拒绝")
+	}
+	if msg := serv.check(&BroadcastData{Kind: BroadcastKindPointer, Payload: json.RawMessage(`"` + strings.Repeat("a", 300) + `"`)}); msg == "" {
+		t.Error("超出大小应拒绝")
+	}
+	if msg := serv.check(&BroadcastData{Kind: BroadcastKindChat, Payload: json.RawMessage(`"hi"`)}); msg == "" {
+		t.Error("只读权限不能聊天")
+	}
+	if msg := serv.check(&BroadcastData{Kind: BroadcastKindPointer, Payload: json.RawMessage(`{"x":1}`)}); msg != "" {
+		t.Errorf("指针应允许：%s", msg)
+	}
+}
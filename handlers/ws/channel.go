@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"kcaitech.com/kcserver/common"
+	handlerCommon "kcaitech.com/kcserver/handlers/common"
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/providers/bus"
+	"kcaitech.com/kcserver/services"
+	"kcaitech.com/kcserver/utils/websocket"
+)
+
+// 一个连接上最多同时绑定的文档数
+const maxDocChannels = 16
+
+type UnbindData struct {
+	DocumentId string `json:"document_id"`
+}
+
+// 连接上绑定的一个文档，各文档的serve及权限相互独立
+type docChannel struct {
+	documentId string
+	versionId  string
+	encoding   Encoding
+
+	mutex    sync.Mutex
+	serveMap map[string]ServeFace
+	revoked  bool // 权限已被收回，需要重新bind
+	quit     chan struct{}
+	quitOnce sync.Once
+
+	// 串行化消息处理与权限变化，权限收回时不会与正在处理的消息并发
+	handleMutex sync.Mutex
+}
+
+// 可在运行中调整权限的serve
+type permTypeSetter interface {
+	setPermType(permType models.PermType)
+}
+
+func newDocChannel(documentId string, versionId string, encoding Encoding) *docChannel {
+	return &docChannel{
+		documentId: documentId,
+		versionId:  versionId,
+		encoding:   encoding,
+		serveMap:   map[string]ServeFace{},
+		quit:       make(chan struct{}),
+	}
+}
+
+func (ch *docChannel) bindServe(t string, s ServeFace) {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	if ch.revoked {
+		if !isInterfaceNil(s) {
+			s.close()
+		}
+		return
+	}
+	bindServe(ch.serveMap, t, s)
+}
+
+// 由连接的读循环调用，分发到该文档的serve，未绑定时返回false
+func (ch *docChannel) handle(t string, data *TransData, binaryData *([]byte)) bool {
+	ch.handleMutex.Lock()
+	defer ch.handleMutex.Unlock()
+	s := ch.getServe(t)
+	if s == nil {
+		return false
+	}
+	s.handle(data, binaryData)
+	return true
+}
+
+func (ch *docChannel) getServe(t string) ServeFace {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	return ch.serveMap[t]
+}
+
+func (ch *docChannel) isRevoked() bool {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	return ch.revoked
+}
+
+func (ch *docChannel) closeServes() {
+	ch.mutex.Lock()
+	defer ch.mutex.Unlock()
+	ch.closeServesLocked()
+}
+
+func (ch *docChannel) closeServesLocked() {
+	for _, h := range ch.serveMap {
+		if nil != h {
+			h.close()
+		}
+	}
+	ch.serveMap = map[string]ServeFace{}
+}
+
+func (ch *docChannel) close() {
+	ch.quitOnce.Do(func() {
+		close(ch.quit)
+	})
+	ch.closeServes()
+}
+
+// 监听文档权限变化，权限收回时关闭该文档的serve，降低时调整serve的权限，并通知客户端
+func (ch *docChannel) watchPermission(ws *websocket.Ws, userId string, genSId func() string) {
+	go func() {
+		subscription, err := bus.SubscribeResumable(context.Background(), services.GetBus(), fmt.Sprintf("%s%s", common.RedisKeyDocumentPermission, ch.documentId), "")
+		if err != nil {
+			log.Println("权限变更订阅失败", ch.documentId, err)
+			return
+		}
+		defer subscription.Close()
+		channel := subscription.Channel()
+		for {
+			select {
+			case v, ok := <-channel:
+				if !ok {
+					return
+				}
+				change := handlerCommon.DocumentPermissionChange{}
+				if err := json.Unmarshal([]byte(v.Payload), &change); err != nil {
+					log.Println("权限变更数据错误", err)
+					continue
+				}
+				if change.UserId != "" && change.UserId != userId {
+					continue
+				}
+				ch.reevaluate(ws, userId, genSId)
+			case <-ch.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (ch *docChannel) reevaluate(ws *websocket.Ws, userId string, genSId func() string) {
+	var permType models.PermType
+	if err := services.NewDocumentService().GetPermTypeByDocumentAndUserId(&permType, ch.documentId, userId); err != nil {
+		if !errors.Is(err, services.ErrRecordNotFound) {
+			log.Println("权限查询失败", ch.documentId, userId, err)
+			return
+		}
+		// 文档已删除
+		permType = models.PermTypeNone
+	}
+
+	serverData := TransData{
+		Type:   DataTypes_Permission,
+		DataId: genSId(),
+		DocId:  ch.documentId,
+	}
+	// 等待正在处理的消息结束
+	ch.handleMutex.Lock()
+	ch.mutex.Lock()
+	if permType < models.PermTypeReadOnly {
+		log.Println("权限已收回，关闭文档serve", ch.documentId, userId)
+		ch.closeServesLocked()
+		ch.revoked = true
+		serverData.Msg = "permission revoked"
+	} else {
+		for _, s := range ch.serveMap {
+			if setter, ok := s.(permTypeSetter); ok {
+				setter.setPermType(permType)
+			}
+		}
+	}
+	ch.mutex.Unlock()
+	ch.handleMutex.Unlock()
+	if data, err := json.Marshal(map[string]any{"perm_type": permType}); err == nil {
+		serverData.Data = string(data)
+	}
+	_ = ws.SendJSON(&serverData)
+}
+
+func bindServe(serveMap map[string]ServeFace, t string, s ServeFace) {
+	old := serveMap[t]
+	if old != nil {
+		(old).close()
+	}
+	if !isInterfaceNil(s) {
+		serveMap[t] = s
+	} else {
+		delete(serveMap, t)
+	}
+}
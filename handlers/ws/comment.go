@@ -12,25 +12,44 @@ package ws
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
 
 	"kcaitech.com/kcserver/common"
+	handlerCommon "kcaitech.com/kcserver/handlers/common"
 	"kcaitech.com/kcserver/models"
-	"kcaitech.com/kcserver/providers/redis"
+	"kcaitech.com/kcserver/providers/bus"
 	"kcaitech.com/kcserver/services"
 	"kcaitech.com/kcserver/utils/websocket"
 )
 
+// 评论的增删改，测试时可替换
+var (
+	createComment    = handlerCommon.CreateComment
+	updateComment    = handlerCommon.UpdateComment
+	deleteComment    = handlerCommon.DeleteComment
+	setCommentStatus = handlerCommon.SetCommentStatus
+)
+
 type commnetServe struct {
 	ws   *websocket.Ws
 	quit chan struct{}
 	// isready bool
-	genSId func() string
-	redis  *redis.RedisDB
+	genSId     func() string
+	documentId string
+	userId     string
+	token      string
+	permType   atomic.Uint32 // models.PermType，权限变化时更新
+
+	userMutex sync.Mutex
+	user      *models.UserProfile // 第一次创建评论时获取
 }
 
-func NewCommentServe(ws *websocket.Ws, userId string, documentId string, genSId func() string) *commnetServe {
+func NewCommentServe(ws *websocket.Ws, token, userId string, documentId string, genSId func() string) *commnetServe {
 
 	// 权限校验
 	var permType models.PermType
@@ -42,10 +61,13 @@ func NewCommentServe(ws *websocket.Ws, userId string, documentId string, genSId
 	serv := commnetServe{
 		ws: ws,
 		// isready: false,
-		genSId: genSId,
-		quit:   make(chan struct{}),
-		redis:  services.GetRedisDB(),
+		genSId:     genSId,
+		documentId: documentId,
+		userId:     userId,
+		token:      token,
+		quit:       make(chan struct{}),
 	}
+	serv.permType.Store(uint32(permType))
 	serv.start(documentId)
 	// serv.isready = true
 	return &serv
@@ -57,14 +79,18 @@ func (serv *commnetServe) start(documentId string) {
 	// 监控评论变化
 	go func() {
 		// defer tunnelServer.Close()
-		pubsub := serv.redis.Client.Subscribe(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentComment, documentId))
-		defer pubsub.Close()
-		channel := pubsub.Channel()
+		subscription, err := bus.SubscribeResumable(context.Background(), services.GetBus(), fmt.Sprintf("%s%s", common.RedisKeyDocumentComment, documentId), "")
+		if err != nil {
+			log.Println("subscribe fail", err)
+			return
+		}
+		defer subscription.Close()
+		channel := subscription.Channel()
 		for {
 			select {
 			case v, ok := <-channel:
 				if !ok {
-					break
+					return
 				}
 				serv.send(v.Payload)
 			case <-serv.quit:
@@ -74,12 +100,105 @@ func (serv *commnetServe) start(documentId string) {
 	}()
 }
 
+func (serv *commnetServe) setPermType(permType models.PermType) {
+	serv.permType.Store(uint32(permType))
+}
+
 func (serv *commnetServe) close() {
 	close(serv.quit)
 }
 
+func (serv *commnetServe) getUser() (*models.UserProfile, error) {
+	serv.userMutex.Lock()
+	defer serv.userMutex.Unlock()
+	if serv.user != nil {
+		return serv.user, nil
+	}
+	userInfo, err := services.GetKCAuthClient().GetUserInfoById(serv.token, serv.userId)
+	if err != nil {
+		return nil, err
+	}
+	serv.user = &models.UserProfile{
+		Id:       userInfo.UserID,
+		Nickname: userInfo.Nickname,
+		Avatar:   userInfo.Avatar,
+	}
+	return serv.user, nil
+}
+
+// 评论的增删改，数据格式同UserCommentPublishData，结果按DataId回复
+// 修改时content为空表示只修改状态（解决/重新打开）
 func (serv *commnetServe) handle(data *TransData, binaryData *([]byte)) {
-	// nothing
+	serverData := TransData{}
+	serverData.Type = data.Type
+	serverData.DataId = data.DataId
+	serverData.DocId = serv.documentId
+
+	msgErr := func(msg string, code int, err error) {
+		serverData.Msg = msg
+		serverData.Code = int32(code)
+		log.Println("comment", msg, err)
+		_ = serv.ws.SendJSON(&serverData)
+	}
+	publishData := models.UserCommentPublishData{}
+	if err := json.Unmarshal([]byte(data.Data), &publishData); err != nil {
+		msgErr("comment数据解码错误", http.StatusBadRequest, err)
+		return
+	}
+	comment := &publishData.Comment
+	if comment.CommentId == "" {
+		msgErr("参数错误：id", http.StatusBadRequest, nil)
+		return
+	}
+	// 以通道绑定的文档为准
+	comment.DocumentId = serv.documentId
+	if models.PermType(serv.permType.Load()) < models.PermTypeCommentable {
+		msgErr("无评论权限", http.StatusForbidden, nil)
+		return
+	}
+
+	var result any
+	var code int
+	var err error
+	switch publishData.Type {
+	case models.UserCommentPublishTypeAdd:
+		// 离线队列重发时由CreateComment返回已创建的评论
+		user, e := serv.getUser()
+		if e != nil {
+			msgErr("用户信息获取失败", http.StatusUnauthorized, e)
+			return
+		}
+		var created *models.UserComment
+		if created, code, err = createComment(user, comment); err == nil {
+			result = created.UserCommentCommon
+		}
+	case models.UserCommentPublishTypeUpdate:
+		if comment.Content == "" {
+			result, code, err = setCommentStatus(serv.userId, &models.UserCommentSetStatus{
+				DocumentId: serv.documentId,
+				Id:         comment.CommentId,
+				Status:     comment.Status,
+			})
+		} else if code, err = updateComment(serv.userId, comment); err == nil {
+			result = comment
+		}
+	case models.UserCommentPublishTypeDel:
+		var deleted int64
+		if deleted, code, err = deleteComment(serv.userId, serv.documentId, comment.CommentId); err == nil {
+			result = map[string]int64{"deleted": deleted}
+		}
+	default:
+		msgErr("不支持的评论操作", http.StatusBadRequest, nil)
+		return
+	}
+	if err != nil {
+		msgErr(err.Error(), code, err)
+		return
+	}
+	if resultData, err := json.Marshal(result); err == nil {
+		serverData.Data = string(resultData)
+	}
+	_ = serv.ws.SendJSON(&serverData)
 }
 
 func (serv *commnetServe) send(data string) {
@@ -88,8 +207,9 @@ func (serv *commnetServe) send(data string) {
 		Type:   DataTypes_Comment,
 		DataId: sid,
 		Data:   data,
+		DocId:  serv.documentId,
 	}
-	if err := serv.ws.WriteJSONLock(true, &serverData); err != nil {
+	if err := serv.ws.SendJSON(&serverData); err != nil {
 		log.Println("comment, send data fail", err)
 		return
 	}
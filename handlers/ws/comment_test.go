@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/utils/websocket"
+)
+
+// 建立一对本地连接，返回服务端和客户端
+func newTestWsPair(t *testing.T) (*websocket.Ws, *websocket.Ws) {
+	serverCh := make(chan *websocket.Ws, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverCh <- ws
+	}))
+	t.Cleanup(srv.Close)
+	client, err := websocket.NewClient("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-serverCh
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func readTransData(t *testing.T, client *websocket.Ws) TransData {
+	t.Helper()
+	result := make(chan TransData, 1)
+	go func() {
+		var data TransData
+		if err := client.ReadJSON(&data); err != nil {
+			t.Error(err)
+		}
+		result <- data
+	}()
+	select {
+	case data := <-result:
+		return data
+	case <-time.After(time.Second):
+		t.Fatal("等待回复超时")
+		return TransData{}
+	}
+}
+
+// 替换评论的增删改，记录调用
+func stubCommentMutations(t *testing.T) *[]string {
+	calls := []string{}
+	oldCreate, oldUpdate, oldDelete, oldSetStatus := createComment, updateComment, deleteComment, setCommentStatus
+	t.Cleanup(func() {
+		createComment, updateComment, deleteComment, setCommentStatus = oldCreate, oldUpdate, oldDelete, oldSetStatus
+	})
+	createComment = func(user *models.UserProfile, comment *models.UserCommentCommon) (*models.UserComment, int, error) {
+		calls = append(calls, "create:"+comment.DocumentId)
+		if comment.CommentId == "taken" {
+			return nil, http.StatusConflict, errors.New("评论id已存在")
+		}
+		return &models.UserComment{UserCommentCommon: *comment, User: user.Id}, 0, nil
+	}
+	updateComment = func(userId string, comment *models.UserCommentCommon) (int, error) {
+		calls = append(calls, "update:"+comment.DocumentId)
+		return 0, nil
+	}
+	deleteComment = func(userId string, documentId string, commentId string) (int64, int, error) {
+		calls = append(calls, "delete:"+documentId)
+		return 1, 0, nil
+	}
+	setCommentStatus = func(userId string, comment *models.UserCommentSetStatus) (*models.UserCommentCommon, int, error) {
+		calls = append(calls, "status:"+comment.DocumentId)
+		return &models.UserCommentCommon{CommentId: comment.Id, Status: comment.Status}, 0, nil
+	}
+	return &calls
+}
+
+func TestCommentServeHandle(t *testing.T) {
+	calls := stubCommentMutations(t)
+	server, client := newTestWsPair(t)
+	serv := &commnetServe{
+		ws:         server,
+		documentId: "doc1",
+		userId:     "u1",
+		user:       &models.UserProfile{Id: "u1"},
+	}
+	serv.setPermType(models.PermTypeCommentable)
+
+	cases := []struct {
+		name     string
+		data     string
+		wantCode int32
+		wantCall string
+	}{
+		{"数据错误", `{`, http.StatusBadRequest, ""},
+		{"缺少id", `{"type":0,"comment":{}}`, http.StatusBadRequest, ""},
+		{"不支持的操作", `{"type":9,"comment":{"id":"c1"}}`, http.StatusBadRequest, ""},
+		// 以通道绑定的文档为准
+		{"创建", `{"type":0,"comment":{"id":"c1","doc_id":"other","content":"hi"}}`, 0, "create:doc1"},
+		{"id冲突", `{"type":0,"comment":{"id":"taken","content":"hi"}}`, http.StatusConflict, "create:doc1"},
+		{"修改", `{"type":2,"comment":{"id":"c1","content":"hello"}}`, 0, "update:doc1"},
+		{"修改状态", `{"type":2,"comment":{"id":"c1","status":1}}`, 0, "status:doc1"},
+		{"删除", `{"type":1,"comment":{"id":"c1"}}`, 0, "delete:doc1"},
+	}
+	for i, c := range cases {
+		*calls = (*calls)[:0]
+		dataId := c.name
+		serv.handle(&TransData{Type: DataTypes_Comment, DataId: dataId, Data: c.data}, nil)
+		reply := readTransData(t, client)
+		if reply.DataId != dataId || reply.DocId != "doc1" {
+			t.Errorf("%d %s：回复错误 %+v", i, c.name, reply)
+		}
+		if reply.Code != c.wantCode {
+			t.Errorf("%d %s：期望code %d，实际%d %s", i, c.name, c.wantCode, reply.Code, reply.Msg)
+		}
+		gotCall := strings.Join(*calls, ",")
+		if gotCall != c.wantCall {
+			t.Errorf("%d %s：期望调用%q，实际%q", i, c.name, c.wantCall, gotCall)
+		}
+		if c.wantCode == 0 && reply.Data == "" {
+			t.Errorf("%d %s：成功时应返回结果", i, c.name)
+		}
+	}
+}
+
+func TestCommentServeCreateResult(t *testing.T) {
+	stubCommentMutations(t)
+	server, client := newTestWsPair(t)
+	serv := &commnetServe{ws: server, documentId: "doc1", userId: "u1", user: &models.UserProfile{Id: "u1"}}
+	serv.setPermType(models.PermTypeCommentable)
+
+	serv.handle(&TransData{Type: DataTypes_Comment, DataId: "1", Data: `{"type":0,"comment":{"id":"c1","content":"hi"}}`}, nil)
+	reply := readTransData(t, client)
+	var comment models.UserCommentCommon
+	if err := json.Unmarshal([]byte(reply.Data), &comment); err != nil {
+		t.Fatal(err)
+	}
+	if comment.CommentId != "c1" || comment.DocumentId != "doc1" || comment.Content != "hi" {
+		t.Errorf("创建结果错误：%+v", comment)
+	}
+}
+
+func TestCommentServePermDowngrade(t *testing.T) {
+	calls := stubCommentMutations(t)
+	server, client := newTestWsPair(t)
+	serv := &commnetServe{ws: server, documentId: "doc1", userId: "u1", user: &models.UserProfile{Id: "u1"}}
+	serv.setPermType(models.PermTypeEditable)
+
+	// 降为只读后不能再修改评论
+	serv.setPermType(models.PermTypeReadOnly)
+	serv.handle(&TransData{Type: DataTypes_Comment, DataId: "1", Data: `{"type":0,"comment":{"id":"c1","content":"hi"}}`}, nil)
+	reply := readTransData(t, client)
+	if reply.Code != http.StatusForbidden {
+		t.Errorf("期望code %d，实际%d %s", http.StatusForbidden, reply.Code, reply.Msg)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("不应调用%v", *calls)
+	}
+}
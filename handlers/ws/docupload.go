@@ -69,7 +69,7 @@ func (serv *docUploadServe) handle(data *TransData, binaryData *([]byte)) {
 		} else {
 			log.Println(msg)
 		}
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 	}
 
 	type UploadHeader struct {
@@ -98,7 +98,7 @@ func (serv *docUploadServe) handle(data *TransData, binaryData *([]byte)) {
 
 	if uploadHeader.Export != nil {
 		serv.data.Export = uploadHeader.Export
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 		return
 	}
 	if uploadHeader.Media != "" && binaryData != nil {
@@ -106,7 +106,7 @@ func (serv *docUploadServe) handle(data *TransData, binaryData *([]byte)) {
 		for _, media := range serv.data.Medias {
 			if media.Name == uploadHeader.Media {
 				log.Println("uploading media already exists", uploadHeader.Media, " size:", uint64(len(*binaryData)))
-				_ = serv.ws.WriteJSON(serverData)
+				_ = serv.ws.SendJSON(serverData)
 				return
 			}
 		}
@@ -118,7 +118,7 @@ func (serv *docUploadServe) handle(data *TransData, binaryData *([]byte)) {
 		serv.data.MediasSize += uint64(len(*binaryData))
 		log.Println("uploading media", uploadHeader.Media, " size:", uint64(len(*binaryData)),
 			"already uploaded media count:", len(serv.data.Medias), " total size:", serv.data.MediasSize)
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 		return
 	}
 	if uploadHeader.Commit && serv.data != nil && serv.data.Export != nil {
@@ -146,7 +146,7 @@ func (serv *docUploadServe) handle(data *TransData, binaryData *([]byte)) {
 				log.Println("resp.Data错误??", err)
 			}
 			serverData.Data = string(retData)
-			_ = serv.ws.WriteJSON(serverData)
+			_ = serv.ws.SendJSON(serverData)
 			serv.data = nil // 已上传成功
 		} else {
 			msgErr(resp.Message, &serverData, &err)
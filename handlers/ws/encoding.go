@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+)
+
+// cmds的编码方式，在bind/start时协商
+type Encoding string
+
+const (
+	EncodingJson    Encoding = "json"
+	EncodingMsgpack Encoding = "msgpack"
+	EncodingCbor    Encoding = "cbor"
+)
+
+// 默认压缩阈值，小于该长度的消息不压缩
+const compressThreshold = 1024
+
+var (
+	msgpackHandle = &codec.MsgpackHandle{WriteExt: true}
+	cborHandle    = &codec.CborHandle{}
+)
+
+// 不支持的编码返回json
+func parseEncoding(s string) Encoding {
+	switch Encoding(s) {
+	case EncodingMsgpack, EncodingCbor:
+		return Encoding(s)
+	}
+	return EncodingJson
+}
+
+func (e Encoding) handle() codec.Handle {
+	switch e {
+	case EncodingMsgpack:
+		return msgpackHandle
+	case EncodingCbor:
+		return cborHandle
+	}
+	return nil
+}
+
+// 将json格式的cmds转为二进制编码，保持与json相同的结构
+func encodeCmdsData(encoding Encoding, cmdsData string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(cmdsData)))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, encoding.handle()).Encode(normalizeNumbers(value)); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// json数字优先转为整数，减小编码后的体积
+func normalizeNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, item := range v {
+			v[k] = normalizeNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeNumbers(item)
+		}
+	}
+	return value
+}
+
+// 与decodeBinaryMessage对应：4字节长度前缀 + json头 + 二进制数据
+func encodeBinaryMessage(header any, data []byte) ([]byte, error) {
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	msg := make([]byte, 4, 4+len(headerData)+len(data))
+	binary.LittleEndian.PutUint32(msg, uint32(len(headerData)))
+	msg = append(msg, headerData...)
+	return append(msg, data...), nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestEncodeCmdsData(t *testing.T) {
+	cmdsData := `[{"version":12,"id":"c1","ops":[{"type":2,"data":1.5}]}]`
+	for _, encoding := range []Encoding{EncodingMsgpack, EncodingCbor} {
+		data, err := encodeCmdsData(encoding, cmdsData)
+		if err != nil {
+			t.Fatal(encoding, err)
+		}
+		var items []map[string]any
+		if err := codec.NewDecoderBytes(data, encoding.handle()).Decode(&items); err != nil {
+			t.Fatal(encoding, err)
+		}
+		if len(items) != 1 || items[0]["id"] != "c1" {
+			t.Fatalf("%s 解码结果错误：%v", encoding, items)
+		}
+		// cbor的正整数解码为uint64
+		switch v := items[0]["version"].(type) {
+		case int64, uint64:
+			if v != int64(12) && v != uint64(12) {
+				t.Errorf("%s 解码结果错误：%v", encoding, v)
+			}
+		default:
+			t.Errorf("%s 整数应保持为整数，实际%T %v", encoding, items[0]["version"], items[0]["version"])
+		}
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	if parseEncoding("msgpack") != EncodingMsgpack || parseEncoding("cbor") != EncodingCbor {
+		t.Error("支持的编码解析错误")
+	}
+	if parseEncoding("") != EncodingJson || parseEncoding("xml") != EncodingJson {
+		t.Error("不支持的编码应返回json")
+	}
+}
+
+func TestEncodeBinaryMessage(t *testing.T) {
+	header := TransData{Type: DataTypes_Op, DataId: "s1"}
+	msg, err := encodeBinaryMessage(&header, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, data, err := decodeBinaryMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := TransData{}
+	if err := json.Unmarshal([]byte(h), &decoded); err != nil || decoded != header {
+		t.Errorf("头部解码错误：%v %v", decoded, err)
+	}
+	if len(data) != 3 || data[2] != 3 {
+		t.Errorf("数据解码错误：%v", data)
+	}
+}
@@ -13,16 +13,17 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
-	"github.com/go-redsync/redsync/v4"
 	com "kcaitech.com/kcserver/common"
 	"kcaitech.com/kcserver/handlers/common"
 	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/providers/bus"
 	"kcaitech.com/kcserver/providers/mongo"
-	"kcaitech.com/kcserver/providers/redis"
 	"kcaitech.com/kcserver/services"
 	"kcaitech.com/kcserver/utils/sliceutil"
 	"kcaitech.com/kcserver/utils/websocket"
@@ -39,12 +40,14 @@ type CmdItem = models.CmdItem
 type ReceiveCmd = models.Cmd
 
 type SendData struct {
-	Type       string   `json:"type"`                  // pullCmdsResult update errorInvalidParams errorNoPermission errorInsertFailed errorPullCmdsFailed
+	Type       string   `json:"type"`                  // pullCmdsResult update replayBegin replayEnd snapshotRequired errorInvalidParams errorNoPermission errorInsertFailed errorPullCmdsFailed
 	CmdsData   string   `json:"cmds_data,omitempty"`   // pullCmdsResult update
-	From       int      `json:"from,omitempty"`        // pullCmdsResult errorPullCmdsFailed
-	To         int      `json:"to,omitempty"`          // pullCmdsResult errorPullCmdsFailed
+	From       int      `json:"from,omitempty"`        // pullCmdsResult errorPullCmdsFailed replayBegin
+	To         int      `json:"to,omitempty"`          // pullCmdsResult errorPullCmdsFailed replayEnd
 	PreviousId string   `json:"previous_id,omitempty"` // pullCmdsResult
 	CmdIdList  []string `json:"cmd_id_list,omitempty"` // errorInsertFailed
+	Encoding   Encoding `json:"encoding,omitempty"`    // 非json编码时cmds在二进制数据中
+	VersionId  string   `json:"version_id,omitempty"`  // snapshotRequired，需要重新加载的文档版本
 	// Data       any      `json:"data,omitempty"`        // errorInsertFailed
 }
 
@@ -53,40 +56,18 @@ type opServe struct {
 	quit chan struct{}
 	// isready bool
 	genSId     func() string
-	mutex      *redsync.Mutex
-	permType   models.PermType
+	mutex      bus.Mutex
+	permType   atomic.Uint32 // models.PermType，权限变化时更新
 	documentId string
 	userId     string
 	// dbModule   *models.DBModule
-	redis *redis.RedisDB
 	// mongo      *mongo.MongoDB
+	committedCmds map[string]uint // 本连接已提交的cmd，客户端本地已包含，变换时需要跳过
+	lastVerId     uint            // 已发送给客户端的最后一个cmd的VerId
+	encoding      Encoding
 }
 
-// 从redis中获取最后一条cmd的id，若redis中没有则从mongodb中获取
-func (serv *opServe) getPreviousId(documentId string) (uint, error) {
-	// documentIdStr := str.IntToString(documentId)
-	previousId, err := serv.redis.Client.Get(context.Background(), fmt.Sprintf("%s%s", com.RedisKeyDocumentLastCmdVerId, documentId)).Int() // redis也是最终一致性
-	if err == nil {
-		return uint(previousId), nil
-	}
-
-	if err != redis.Nil { // todo redis获取不到时，应该去mongodb获取，并更新到redis？
-		log.Println(fmt.Sprintf("%s%s", com.RedisKeyDocumentLastCmdVerId, documentId), "获取失败", err)
-		return 0, err
-	}
-
-	cmdService := services.GetCmdService()
-	cmdItem, err := cmdService.GetLastCmdItem(documentId)
-	if err != nil {
-		return 0, err
-	}
-	if cmdItem != nil {
-		return cmdItem.VerId, nil
-	}
-	return 0, nil
-}
-
-func NewOpServe(ws *websocket.Ws, userId string, documentId string, versionId string, lastCmdVerId uint, genSId func() string) *opServe {
+func NewOpServe(ws *websocket.Ws, userId string, documentId string, versionId string, lastCmdVerId uint, encoding Encoding, genSId func() string) *opServe {
 
 	documentService := services.NewDocumentService()
 	var document models.Document
@@ -130,60 +111,81 @@ func NewOpServe(ws *websocket.Ws, userId string, documentId string, versionId st
 	}
 
 	// documentIdStr := str.IntToString(documentId)
-	redis := services.GetRedisDB()
-	mutex := redis.RedSync.NewMutex(fmt.Sprintf("%s%s", com.RedisKeyDocumentOpMutex, documentId), redsync.WithExpiry(time.Second*10))
+	mutex := services.GetBus().NewMutex(fmt.Sprintf("%s%s", com.RedisKeyDocumentOpMutex, documentId), time.Second*10)
 
 	serv := opServe{
 		ws: ws,
 		// isready: false,
 		genSId:     genSId,
 		mutex:      mutex,
-		permType:   permType,
 		documentId: documentId,
 		userId:     userId,
 		quit:       make(chan struct{}),
 		// dbModule:   dbModule,
-		redis: redis,
 		// mongo:      mongo,
+		committedCmds: map[string]uint{},
+		encoding:      encoding,
 	}
 
 	if lastCmdVerId == 0 {
 		lastCmdVerId = (documentVersion.LastCmdVerId)
 	}
 
+	serv.permType.Store(uint32(permType))
 	serv.start(documentId, lastCmdVerId)
 	// serv.isready = true
 	return &serv
 }
 
+const (
+	replayChunkSize  = 500 // 初始回放每次发送的cmd数量
+	replayMaxQueued  = 8   // 回放时发送队列中最多的消息数
+	replayMaxPending = 256 // 回放期间最多缓存的广播数
+)
+
 func (serv *opServe) start(documentId string, lastCmdVersion uint) {
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
-		cmdService := services.GetCmdService()
-		cmdItemList, err := cmdService.GetCmdItemsFromStart(documentId, lastCmdVersion)
+		defer cancel()
+		// 先订阅再查询，查询期间发布的cmds由连续性检查去重
+		// documentIdStr := str.IntToString(documentId)
+		subscription, err := bus.SubscribeResumable(ctx, services.GetBus(), fmt.Sprintf("%s%s", com.RedisKeyDocumentOp, documentId), "")
 		if err != nil {
-			log.Println("cmdList查询失败", err)
+			log.Println("op订阅失败", err)
 			return
 		}
-		cmdItemListData, err := json.Marshal(cmdItemList)
-		if err != nil {
-			log.Println("json编码错误 cmdItemsData", err)
-			return
-		}
-		log.Println(fmt.Sprintf("%s%s", com.RedisKeyDocumentOp, documentId), "获取成功", len(cmdItemList), lastCmdVersion)
-		serv.send(string(cmdItemListData))
+		defer subscription.Close()
+		channel := subscription.Channel()
 
-		// documentIdStr := str.IntToString(documentId)
-		pubsub := serv.redis.Client.Subscribe(context.Background(), fmt.Sprintf("%s%s", com.RedisKeyDocumentOp, documentId))
-		defer pubsub.Close()
-		channel := pubsub.Channel()
+		replayDone := make(chan struct{})
+		go func() {
+			serv.replay(ctx, lastCmdVersion)
+			close(replayDone)
+		}()
+
+		// 回放完成前收到的广播先缓存，保证顺序
+		var pending []string
 		for {
 			select {
 			case v, ok := <-channel:
 				if !ok {
-					break
+					return
+				}
+				if replayDone != nil {
+					if len(pending) >= replayMaxPending {
+						// 超出上限时只保留最新的一条，回放结束后由连续性检查从数据库补齐中间的cmds
+						pending = pending[:0]
+					}
+					pending = append(pending, v.Payload)
+					continue
+				}
+				serv.sendContinuous(v.Payload)
+			case <-replayDone:
+				replayDone = nil
+				for _, payload := range pending {
+					serv.sendContinuous(payload)
 				}
-				// todo 需要判断下当前lastversion版本,当接不上时需要拉取cmds
-				serv.send(v.Payload)
+				pending = nil
 			case <-serv.quit:
 				return
 			}
@@ -191,40 +193,323 @@ func (serv *opServe) start(documentId string, lastCmdVersion uint) {
 	}()
 }
 
+// 分块发送lastCmdVersion之后的cmds，以replayBegin/replayEnd标记回放的起止
+func (serv *opServe) replay(ctx context.Context, lastCmdVersion uint) {
+	if lastCmdVersion > 0 {
+		serv.lastVerId = lastCmdVersion - 1
+	}
+	if _, err := services.GetCmdService().CheckCmdsAvailable(serv.documentId, lastCmdVersion); err != nil {
+		if errors.Is(err, models.ErrSnapshotRequired) {
+			// 客户端的版本之后的cmds已被删除，无法回放
+			serv.sendSnapshotRequired(&TransData{Type: DataTypes_Op, DataId: serv.genSId(), DocId: serv.documentId})
+			return
+		}
+		log.Println("cmd压缩状态查询失败", serv.documentId, err)
+	}
+	serv.sendMarker("replayBegin", "", int(lastCmdVersion))
+
+	count := 0
+	err := services.GetCmdService().IterCmdItemsFromStart(ctx, serv.documentId, lastCmdVersion, replayChunkSize, func(cmdItemList []CmdItem) error {
+		cmdItemListData, err := json.Marshal(cmdItemList)
+		if err != nil {
+			return err
+		}
+		// 客户端接收过慢时等待，避免回放占满发送队列
+		if err := serv.ws.WaitQueueBelow(ctx, replayMaxQueued); err != nil {
+			return err
+		}
+		if err := serv.sendUpdate(string(cmdItemListData)); err != nil {
+			return err
+		}
+		serv.lastVerId = cmdItemList[len(cmdItemList)-1].VerId
+		count += len(cmdItemList)
+		return nil
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	msg := ""
+	if err != nil {
+		// 客户端可从replayEnd的版本之后通过pullCmds补齐
+		log.Println("cmd回放失败", serv.documentId, lastCmdVersion, err)
+		msg = "replay failed"
+	}
+	log.Println(fmt.Sprintf("%s%s", com.RedisKeyDocumentOp, serv.documentId), "回放完成", count, lastCmdVersion)
+	serv.sendMarker("replayEnd", msg, int(serv.lastVerId))
+}
+
+// 所需的cmds已被删除，通知客户端从文档当前版本重新加载
+func (serv *opServe) sendSnapshotRequired(serverData *TransData) {
+	var document models.Document
+	if err := services.NewDocumentService().GetById(serv.documentId, &document); err != nil {
+		log.Println("op, 文档查询失败", serv.documentId, err)
+	}
+	serverData.Msg = "snapshot required"
+	sendData := SendData{Type: "snapshotRequired", VersionId: document.VersionId}
+	if err := serv.writeSendData(serverData, &sendData); err != nil {
+		log.Println("op, send snapshotRequired fail", err)
+	}
+}
+
+func (serv *opServe) sendMarker(markerType string, msg string, verId int) {
+	sendData := SendData{Type: markerType}
+	if markerType == "replayBegin" {
+		sendData.From = verId
+	} else {
+		sendData.To = verId
+	}
+	serverData := TransData{
+		Type:   DataTypes_Op,
+		DataId: serv.genSId(),
+		DocId:  serv.documentId,
+		Msg:    msg,
+	}
+	if err := serv.writeSendData(&serverData, &sendData); err != nil {
+		log.Println("op, send marker fail", markerType, err)
+	}
+}
+
+// 检查发布的cmds是否与已发送的版本连续，接不上时从数据库拉取缺失的部分
+func (serv *opServe) sendContinuous(data string) {
+	var verIds []struct {
+		VerId uint `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(data), &verIds); err != nil || len(verIds) == 0 {
+		log.Println("op, redis data wrong", err)
+		return
+	}
+	first := verIds[0].VerId
+	last := verIds[len(verIds)-1].VerId
+	if last <= serv.lastVerId {
+		// 已发送过
+		return
+	}
+	if first != serv.lastVerId+1 {
+		log.Println("op, cmds不连续", serv.documentId, serv.lastVerId, first, last)
+		cmdItemList, err := services.GetCmdService().GetCmdItems(serv.documentId, serv.lastVerId+1, last)
+		if err != nil {
+			// 客户端可通过pullCmds补齐
+			log.Println("op, 补齐cmds失败", err)
+		} else if cmdItemListData, err := json.Marshal(cmdItemList); err != nil {
+			log.Println("json编码错误 cmdItemsData", err)
+		} else {
+			data = string(cmdItemListData)
+		}
+	}
+	// 发送失败时不前移，下次广播时重新检测并补齐
+	if err := serv.sendUpdate(data); err != nil {
+		log.Println("op, send data fail", err)
+		return
+	}
+	serv.lastVerId = last
+}
+
+func (serv *opServe) setPermType(permType models.PermType) {
+	serv.permType.Store(uint32(permType))
+}
+
 func (serv *opServe) close() {
 	close(serv.quit)
 }
 
-func (serv *opServe) send(data string) {
-	// jsonData := &Data{}
-	// if err := json.Unmarshal([]byte(data), jsonData); err != nil {
-	// 	log.Println("comment, redis data wrong", err)
-	// 	return
-	// }
-
+func (serv *opServe) sendUpdate(data string) error {
 	sendData := SendData{
 		Type:     "update",
 		CmdsData: data,
 	}
+	serverData := TransData{
+		Type:   DataTypes_Op,
+		DataId: serv.genSId(),
+		DocId:  serv.documentId,
+	}
+	return serv.writeSendData(&serverData, &sendData)
+}
 
-	bytes, err := json.Marshal(&sendData)
+// 按协商的编码发送cmds，非json编码时以二进制消息发送
+func (serv *opServe) writeSendData(serverData *TransData, sendData *SendData) error {
+	var cmdsBinary []byte
+	if serv.encoding != EncodingJson && sendData.CmdsData != "" {
+		var err error
+		if cmdsBinary, err = encodeCmdsData(serv.encoding, sendData.CmdsData); err != nil {
+			return err
+		}
+		sendData.CmdsData = ""
+		sendData.Encoding = serv.encoding
+	}
+	bytes, err := json.Marshal(sendData)
 	if err != nil {
-		log.Println("op, marshal data fail", err)
-		return
+		return err
+	}
+	serverData.Data = string(bytes)
+	if cmdsBinary == nil {
+		return serv.ws.SendJSON(serverData)
 	}
+	msg, err := encodeBinaryMessage(serverData, cmdsBinary)
+	if err != nil {
+		return err
+	}
+	return serv.ws.Send(websocket.MessageTypeBinary, msg)
+}
 
-	sid := serv.genSId()
-	serverData := TransData{
-		Type:   DataTypes_Op,
-		DataId: sid,
-		Data:   string(bytes),
+var errDocumentRestored = errors.New("document restored")
+
+// 将基于旧版本提交的cmds变换到最新版本之后
+func (serv *opServe) rebaseCmds(cmds []ReceiveCmd, baseVer uint, headVer uint) ([]ReceiveCmd, error) {
+	// 客户端已确认的cmd不再需要记录
+	for cmdId, verId := range serv.committedCmds {
+		if verId <= baseVer {
+			delete(serv.committedCmds, cmdId)
+		}
 	}
-	if err := serv.ws.WriteJSONLock(true, &serverData); err != nil {
-		log.Println("op, send data fail", err)
-		return
+	cmdService := services.GetCmdService()
+	cmdItemList, err := cmdService.GetCmdItemsNoGap(serv.documentId, baseVer+1, headVer)
+	if err != nil {
+		return nil, err
+	}
+	applied := sliceutil.FilterT(func(item CmdItem) bool {
+		_, ok := serv.committedCmds[item.Cmd.Id]
+		return !ok && !item.Cmd.IsGap()
+	}, cmdItemList...)
+	if len(applied) == 0 {
+		return cmds, nil
+	}
+	for _, item := range applied {
+		if item.Cmd.IsRestore() {
+			// 文档已恢复到历史版本，旧版本上的修改无法变换
+			return nil, errDocumentRestored
+		}
+	}
+	log.Println("rebase cmds", serv.documentId, baseVer, headVer, len(applied))
+	cmds = models.TransformCmds(cmds, applied)
+	for i := range cmds {
+		cmds[i].BaseVer = headVer
+	}
+	return cmds, nil
+}
+
+// 过滤掉已保存的cmd（客户端重发）
+func (serv *opServe) filterSavedCmds(cmds []ReceiveCmd) ([]ReceiveCmd, error) {
+	cmdIdList := sliceutil.MapT(func(cmd ReceiveCmd) string {
+		return cmd.Id
+	}, cmds...)
+	savedCmds, err := services.GetCmdService().GetCmdsByIds(serv.documentId, cmdIdList)
+	if err != nil {
+		return nil, err
+	}
+	savedIds := make(map[string]struct{}, len(savedCmds))
+	for _, item := range savedCmds {
+		savedIds[item.Cmd.Id] = struct{}{}
+	}
+	return sliceutil.FilterT(func(cmd ReceiveCmd) bool {
+		_, ok := savedIds[cmd.Id]
+		return !ok
+	}, cmds...), nil
+}
+
+const maxCommitRetry = 3
+
+// 分配版本并保存cmds，返回已保存的cmd及是否进行了变换
+// 数据重复时（客户端重发或版本序列落后）在服务端重试，不再交给客户端处理
+func (serv *opServe) saveCmds(cmds []ReceiveCmd) ([]CmdItem, bool, error) {
+	cmdService := services.GetCmdService()
+	savedList := make([]CmdItem, 0, len(cmds))
+	rebased := false
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			var err error
+			if cmds, err = serv.filterSavedCmds(cmds); err != nil {
+				return savedList, rebased, err
+			}
+		}
+		if len(cmds) == 0 {
+			return savedList, rebased, nil
+		}
+
+		count := uint(len(cmds))
+		batchStartId, err := cmdService.AllocVerIds(serv.documentId, count)
+		if err != nil {
+			return savedList, rebased, err
+		}
+
+		// 提交的cmds基于旧版本，需要在服务端变换到最新版本
+		// 顺序只由版本计数器保证，headVer之前的版本可能仍在保存中（如文档锁已过期），有空缺时释放锁后等待或占位
+		headVer := batchStartId - 1
+		if baseVer := cmds[0].BaseVer; baseVer < headVer {
+			cmds, err = serv.rebaseCmds(cmds, baseVer, headVer)
+			if err != nil {
+				serv.releaseVerIds(batchStartId, count)
+				return savedList, rebased, err
+			}
+			rebased = rebased || cmds[0].BaseVer == headVer
+		}
+
+		cmdItemList := make([]CmdItem, 0, len(cmds))
+		for i, cmd := range cmds {
+			cmdItemList = append(cmdItemList, CmdItem{
+				VerId:        batchStartId + uint(i),
+				BatchStartId: batchStartId,
+				BatchLength:  count,
+				DocumentId:   serv.documentId,
+				UserId:       serv.userId,
+				Cmd:          cmd,
+			})
+		}
+
+		_, err = cmdService.SaveCmdItems(cmdItemList)
+		if err == nil {
+			return append(savedList, cmdItemList...), rebased, nil
+		}
+
+		// 有序插入，出错位置之前的已保存
+		inserted, _ := mongo.GetWriteErrorIndex(err)
+		savedList = append(savedList, cmdItemList[:inserted]...)
+		for _, item := range cmdItemList[:inserted] {
+			// 剩余的cmd基于这些cmd，重试变换时需要跳过
+			serv.committedCmds[item.Cmd.Id] = item.VerId
+		}
+		serv.releaseVerIds(batchStartId+uint(inserted), count-uint(inserted))
+		if !mongo.IsDuplicateKeyError(err) || retry >= maxCommitRetry {
+			return savedList, rebased, err
+		}
+		log.Println("重复数据插入失败，重试", serv.documentId, retry, err)
+		// ver_id重复说明版本序列落后于已有的cmd
+		if err := cmdService.SyncVerSeq(serv.documentId); err != nil {
+			return savedList, rebased, err
+		}
+		cmds = cmds[inserted:]
+	}
+}
+
+func (serv *opServe) releaseVerIds(start uint, count uint) {
+	released, err := services.GetCmdService().ReleaseVerIds(serv.documentId, start, count)
+	if err != nil || !released {
+		// 无法归还时版本号会出现空缺，之后的变换读取时以空cmd占位
+		log.Println("归还版本号失败", serv.documentId, start, count, err)
 	}
 }
 
+// 保存cmds并广播，需持有文档锁
+func (serv *opServe) saveAndPublish(cmds []ReceiveCmd) ([]CmdItem, bool, error) {
+	cmdItemList, rebased, err := serv.saveCmds(cmds)
+	documentId := (serv.documentId)
+	if len(cmdItemList) > 0 {
+		for _, item := range cmdItemList {
+			serv.committedCmds[item.Cmd.Id] = item.VerId
+		}
+		// 部分保存成功时也需要广播，已保存的cmd不会回滚
+		if cmdItemListData, err := json.Marshal(cmdItemList); err != nil {
+			log.Println("json marshal failed", err)
+		} else {
+			log.Println("收到cmd广播", len(cmdItemListData), documentId)
+			if err := services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", com.RedisKeyDocumentOp, documentId), cmdItemListData); err != nil { // 通知客户端是通过redis订阅来触发的
+				log.Println("cmd广播失败", documentId, err)
+			}
+		}
+		common.MarkDocumentDirty(serv.documentId, services.GetConfig())
+	}
+	return cmdItemList, rebased, err
+}
+
 func (serv *opServe) handleCommit(data *TransData, receiveData *ReceiveData) {
 	serverData := TransData{}
 	serverData.Type = data.Type
@@ -237,7 +522,7 @@ func (serv *opServe) handleCommit(data *TransData, receiveData *ReceiveData) {
 		} else {
 			log.Println(msg)
 		}
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 	}
 
 	// var receiveData = ReceiveData{}
@@ -246,7 +531,7 @@ func (serv *opServe) handleCommit(data *TransData, receiveData *ReceiveData) {
 	// 	return
 	// }
 
-	if serv.permType < models.PermTypeEditable {
+	if models.PermType(serv.permType.Load()) < models.PermTypeEditable {
 		msgErr("has no permision", &serverData, nil)
 		return
 	}
@@ -264,99 +549,63 @@ func (serv *opServe) handleCommit(data *TransData, receiveData *ReceiveData) {
 	// 	return cmd.Id
 	// }, cmds...)
 
-	// 上锁
-	if err := serv.mutex.Lock(); err != nil {
-		msgErr("获取锁失败", &serverData, &err)
-		return
-	}
-	defer func() {
+	var cmdItemList []CmdItem
+	rebased := false
+	var err error
+	for retry := 0; ; retry++ {
+		// 上锁
+		if err := serv.mutex.Lock(); err != nil {
+			msgErr("获取锁失败", &serverData, &err)
+			return
+		}
+		savedList, r, e := serv.saveAndPublish(cmds)
 		if _, err := serv.mutex.Unlock(); err != nil {
 			log.Println("释放锁失败 documentOpMutex.Unlock", err)
 		}
-	}()
-
-	// 从redis中获取最后一条cmd的id，若redis中没有则从mongodb中获取
-	previousId, err := serv.getPreviousId(serv.documentId)
-	if err != nil {
-		msgErr("get previous id failed", &serverData, &err)
-		return
-	}
-
-	batchStartId := previousId + 1
-	batchLength := len(cmds)
-
-	cmdItemList := sliceutil.MapT(func(cmd ReceiveCmd) CmdItem {
-		previousId += 1
-		return CmdItem{
-			VerId:        previousId, // 有redis锁 todo 不对，不能保证唯一
-			BatchStartId: batchStartId,
-			BatchLength:  uint(batchLength),
-			DocumentId:   serv.documentId,
-			UserId:       serv.userId,
-			Cmd:          cmd,
+		cmdItemList = append(cmdItemList, savedList...)
+		rebased = rebased || r
+		err = e
+		var gapErr *models.VerGapError
+		if !errors.As(err, &gapErr) || retry >= maxCommitRetry {
+			break
+		}
+		// 在锁外等待空缺的版本，不阻塞该文档的其它提交，之后重新提交未保存的cmds
+		if err = services.GetCmdService().FillVerGap(serv.documentId, gapErr.VerIds); err != nil {
+			break
+		}
+		savedIds := make(map[string]struct{}, len(savedList))
+		for _, item := range savedList {
+			savedIds[item.Cmd.Id] = struct{}{}
+		}
+		cmds = sliceutil.FilterT(func(cmd ReceiveCmd) bool {
+			_, ok := savedIds[cmd.Id]
+			return !ok
+		}, cmds...)
+		if len(cmds) == 0 {
+			break
 		}
-	}, cmds...)
-
-	cmdItemListData, err := json.Marshal(cmdItemList)
-	if err != nil {
-		msgErr("json marshal failed", &serverData, &err)
-		return
 	}
-	documentId := (serv.documentId)
-
-	// 先删除，防止插入失败时无法正确更新lastCmdVerId
-	if _, err = serv.redis.Client.Del(context.Background(), fmt.Sprintf("%s%s", com.RedisKeyDocumentLastCmdVerId, documentId)).Result(); err != nil {
-		msgErr("redis del fail", &serverData, &err)
+	if errors.Is(err, errDocumentRestored) {
+		// 客户端需要重新加载文档
+		serverData.Data = `{"type":"restored"}`
+		msgErr("document restored", &serverData, &err)
 		return
 	}
-
-	cmdServices := services.GetCmdService()
-	insertRes, err := cmdServices.SaveCmdItems(cmdItemList)
-
-	if err != nil && mongo.IsDuplicateKeyError(err) {
-		log.Println("重复数据插入失败1", err)
-
-		index := len(insertRes.InsertedIDs)
-		duplicateCmdCmdId := cmdItemList[index].Cmd.Id
-		duplicateCmdDocumentId := cmdItemList[index].DocumentId
-		duplicateCmd, err := cmdServices.GetCmd(duplicateCmdDocumentId, duplicateCmdCmdId)
-		if err != nil {
-			log.Println("重复数据查询失败", duplicateCmdDocumentId, duplicateCmdCmdId, err)
-			msgErr("数据插入失败", &serverData, &err)
-			return
-		}
-
-		duplicate := map[string]any{}
-
-		duplicate["type"] = "duplicate"
-		duplicate["duplicateCmd"] = duplicateCmd
-
-		duplicateStr, err := json.Marshal(duplicate)
-		if err != nil {
-			msgErr("数据插入失败", &serverData, &err)
-			return
-		}
-
-		serverData.Data = string(duplicateStr)
-		msgErr("duplicate", &serverData, &err)
+	if errors.Is(err, models.ErrSnapshotRequired) {
+		serv.sendSnapshotRequired(&serverData)
 		return
-
-	} else if err != nil {
+	}
+	if err != nil {
 		msgErr("数据插入失败", &serverData, &err)
 		return
-	} else {
-		if _, err = serv.redis.Client.Set(context.Background(), fmt.Sprintf("%s%s", com.RedisKeyDocumentLastCmdVerId, documentId), previousId, time.Hour*1).Result(); err != nil {
-			log.Println(fmt.Sprintf("%s%s", com.RedisKeyDocumentLastCmdVerId, documentId), "设置失败", err)
-			// return errors.New("数据插入失败")
+	}
+	if rebased {
+		// 客户端需要以广播中的cmds替换本地的
+		if rebasedData, err := json.Marshal(map[string]any{"type": "rebased", "baseVer": cmdItemList[0].VerId - 1}); err == nil {
+			serverData.Data = string(rebasedData)
 		}
-		log.Println("收到cmd广播", len(cmdItemListData), documentId)
-		serv.redis.Client.Publish(context.Background(), fmt.Sprintf("%s%s", com.RedisKeyDocumentOp, documentId), cmdItemListData) // 通知客户端是通过redis订阅来触发的
-		// return nil
-		// debug
-		// log.Panic()
-		_ = serv.ws.WriteJSON(serverData) // sucess
-		go common.AutoUpdate(serv.documentId, services.GetConfig())
 	}
+	_ = serv.ws.SendJSON(serverData) // sucess
 }
 
 func (serv *opServe) handlePullCmds(data *TransData, receiveData *ReceiveData) {
@@ -366,7 +615,7 @@ func (serv *opServe) handlePullCmds(data *TransData, receiveData *ReceiveData) {
 	msgErr := func(msg string, serverData *TransData, err *error) {
 		serverData.Msg = msg
 		log.Println(msg, err)
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 	}
 
 	var cmdItemList []CmdItem
@@ -376,6 +625,13 @@ func (serv *opServe) handlePullCmds(data *TransData, receiveData *ReceiveData) {
 	log.Println("handlePullCmds", receiveData)
 
 	cmdsService := services.GetCmdService()
+	if _, err := cmdsService.CheckCmdsAvailable(serv.documentId, uint(fromId)); errors.Is(err, models.ErrSnapshotRequired) {
+		serv.sendSnapshotRequired(&serverData)
+		return
+	} else if err != nil {
+		msgErr("数据查询失败", &serverData, &err)
+		return
+	}
 	if toId == 0 {
 		cmdItemList, err = cmdsService.GetCmdItemsFromStart(serv.documentId, uint(fromId))
 	} else {
@@ -406,20 +662,14 @@ func (serv *opServe) handlePullCmds(data *TransData, receiveData *ReceiveData) {
 		// PreviousId: str.IntToString(previousId),
 	}
 
-	sendDataStr, err := json.Marshal(sendData)
-	if err != nil {
-		msgErr("json编码错误", &serverData, &err)
-		return
-	}
-	log.Println("pullCmdsEnd", string(sendDataStr))
-	serverData.Data = string(sendDataStr)
-	err = serv.ws.WriteJSON(serverData)
+	log.Println("pullCmdsEnd", len(cmdItemList))
+	err = serv.writeSendData(&serverData, &sendData)
 	if err != nil {
 		log.Println("数据发送失败", err)
 		// msgErr("数据发送失败", &serverData, &err)
 		return
 	} else {
-		log.Println("pullCmdsEnd 数据发送成功", sendData)
+		log.Println("pullCmdsEnd 数据发送成功", sendData.From, sendData.To)
 	}
 
 }
@@ -432,7 +682,7 @@ func (serv *opServe) handle(data *TransData, binaryData *([]byte)) {
 	msgErr := func(msg string, serverData *TransData, err *error) {
 		serverData.Msg = msg
 		log.Println(msg, err)
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 	}
 
 	var receiveData = ReceiveData{}
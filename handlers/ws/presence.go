@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"kcaitech.com/kcserver/common"
+	"kcaitech.com/kcserver/providers/redis"
+)
+
+// 文档内各用户的选区数据，有redis时存redis，否则存在当前实例（单实例部署）
+var (
+	localSelectionData      = map[string]map[string]string{}
+	localSelectionDataMutex sync.Mutex
+)
+
+func selectionDataKey(documentId string) string {
+	return fmt.Sprintf("%s%s", common.RedisKeyDocumentSelectionData, documentId)
+}
+
+func saveSelectionData(rdb *redis.RedisDB, documentId string, userId string, data string) {
+	if rdb != nil {
+		rdb.Client.HSet(context.Background(), selectionDataKey(documentId), userId, data)
+		rdb.Client.Expire(context.Background(), selectionDataKey(documentId), time.Hour*1)
+		return
+	}
+	localSelectionDataMutex.Lock()
+	defer localSelectionDataMutex.Unlock()
+	users, ok := localSelectionData[documentId]
+	if !ok {
+		users = map[string]string{}
+		localSelectionData[documentId] = users
+	}
+	users[userId] = data
+}
+
+func deleteSelectionData(rdb *redis.RedisDB, documentId string, userId string) {
+	if rdb != nil {
+		rdb.Client.HDel(context.Background(), selectionDataKey(documentId), userId)
+		return
+	}
+	localSelectionDataMutex.Lock()
+	defer localSelectionDataMutex.Unlock()
+	users := localSelectionData[documentId]
+	delete(users, userId)
+	if len(users) == 0 {
+		delete(localSelectionData, documentId)
+	}
+}
+
+func getSelectionData(rdb *redis.RedisDB, documentId string, userId string) (string, bool) {
+	if rdb != nil {
+		data, err := rdb.Client.HGet(context.Background(), selectionDataKey(documentId), userId).Result()
+		return data, err == nil
+	}
+	localSelectionDataMutex.Lock()
+	defer localSelectionDataMutex.Unlock()
+	data, ok := localSelectionData[documentId][userId]
+	return data, ok
+}
+
+// userId -> 选区数据
+func listSelectionData(rdb *redis.RedisDB, documentId string) map[string]string {
+	if rdb != nil {
+		data, err := rdb.Client.HGetAll(context.Background(), selectionDataKey(documentId)).Result()
+		if err != nil {
+			log.Println("获取选区数据失败", documentId, err)
+			return nil
+		}
+		return data
+	}
+	localSelectionDataMutex.Lock()
+	defer localSelectionDataMutex.Unlock()
+	result := make(map[string]string, len(localSelectionData[documentId]))
+	for userId, data := range localSelectionData[documentId] {
+		result[userId] = data
+	}
+	return result
+}
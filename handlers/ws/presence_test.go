@@ -0,0 +1,31 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import "testing"
+
+func TestLocalSelectionData(t *testing.T) {
+	saveSelectionData(nil, "doc", "u1", "a")
+	saveSelectionData(nil, "doc", "u2", "b")
+	saveSelectionData(nil, "doc", "u1", "c")
+	roster := listSelectionData(nil, "doc")
+	if len(roster) != 2 || roster["u1"] != "c" || roster["u2"] != "b" {
+		t.Errorf("选区数据错误：%v", roster)
+	}
+	if data, ok := getSelectionData(nil, "doc", "u2"); !ok || data != "b" {
+		t.Errorf("获取选区数据错误：%s %v", data, ok)
+	}
+	deleteSelectionData(nil, "doc", "u1")
+	deleteSelectionData(nil, "doc", "u2")
+	if _, ok := localSelectionData["doc"]; ok {
+		t.Error("用户全部退出后应清理文档数据")
+	}
+}
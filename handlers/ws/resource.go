@@ -77,7 +77,7 @@ func (serv *resourceServe) handle(data *TransData, binaryData *([]byte)) {
 		} else {
 			log.Println(msg)
 		}
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 	}
 
 	if binaryData == nil {
@@ -116,7 +116,7 @@ func (serv *resourceServe) handle(data *TransData, binaryData *([]byte)) {
 	}
 	log.Println("上传成功", serv.documentId, path)
 
-	_ = serv.ws.WriteJSON(&serverData)
+	_ = serv.ws.SendJSON(&serverData)
 	serv.dbModule.DB.Model(&document).Where("id = ?", serv.documentId).UpdateColumn("size", gorm.Expr("size + ?", len(*binaryData)))
 
 	if serv.review != nil {
@@ -15,10 +15,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"kcaitech.com/kcserver/common"
 	"kcaitech.com/kcserver/models"
+	"kcaitech.com/kcserver/providers/bus"
 	"kcaitech.com/kcserver/providers/redis"
 	"kcaitech.com/kcserver/services"
 	"kcaitech.com/kcserver/utils/websocket"
@@ -37,6 +40,21 @@ type DocSelectionData struct {
 	// Nickname          string          `json:"nickname,omitempty"`
 	User      *models.UserProfile `json:"user,omitempty"`
 	EnterTime int64               `json:"enter_time,omitempty"`
+	// 视口只发送给跟随该用户的客户端
+	Viewport *Viewport `json:"viewport,omitempty"`
+}
+
+type Viewport struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Scale  float64 `json:"scale"`
+}
+
+// 跟随某个用户，FollowUserId为空时取消跟随
+type FollowData struct {
+	FollowUserId *string `json:"follow_user_id"`
 }
 
 type DocSelectionOpType uint8
@@ -44,12 +62,15 @@ type DocSelectionOpType uint8
 const (
 	DocSelectionOpTypeUpdate DocSelectionOpType = iota
 	DocSelectionOpTypeExit
+	DocSelectionOpTypeRoster   // 进入文档时的在线用户列表
+	DocSelectionOpTypeViewport // 被跟随用户的页面及视口
 )
 
 type DocSelectionOpData struct {
-	Type   DocSelectionOpType `json:"type"`
-	UserId string             `json:"user_id"`
-	Data   *DocSelectionData  `json:"data,omitempty"`
+	Type   DocSelectionOpType  `json:"type"`
+	UserId string              `json:"user_id"`
+	Data   *DocSelectionData   `json:"data,omitempty"`
+	Roster []*DocSelectionData `json:"roster,omitempty"`
 }
 
 type selectionServe struct {
@@ -60,8 +81,12 @@ type selectionServe struct {
 	userId     string
 	user       *models.UserProfile
 	enterTime  int64
-	permType   models.PermType
-	redis      *redis.RedisDB
+	permType   atomic.Uint32  // models.PermType，权限变化时更新
+	redis      *redis.RedisDB // 可能为nil
+
+	followMutex  sync.Mutex
+	followUserId string
+	followQuit   chan struct{}
 }
 
 func NewSelectionServe(ws *websocket.Ws, token, userId string, documentId string, genSId func() string) *selectionServe {
@@ -100,6 +125,10 @@ func NewSelectionServe(ws *websocket.Ws, token, userId string, documentId string
 		Avatar:   userInfo.Avatar,
 	}
 
+	var redis *redis.RedisDB
+	if services.HasRedisDB() {
+		redis = services.GetRedisDB()
+	}
 	serv := selectionServe{
 		ws:         ws,
 		genSId:     genSId,
@@ -107,10 +136,10 @@ func NewSelectionServe(ws *websocket.Ws, token, userId string, documentId string
 		userId:     userId,
 		user:       &userProfile,
 		enterTime:  time.Now().UnixNano() / 1000000,
-		permType:   permType,
 		quit:       make(chan struct{}),
-		redis:      services.GetRedisDB(),
+		redis:      redis,
 	}
+	serv.permType.Store(uint32(permType))
 	serv.start(documentId)
 	// serv.isready = true
 	return &serv
@@ -122,14 +151,20 @@ func (serv *selectionServe) start(documentId string) {
 	// 监控选区变化
 	go func() {
 		// defer tunnelServer.Close()
-		subscribe := serv.redis.Client.Subscribe(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelection, documentId))
-		defer subscribe.Close()
-		channel := subscribe.Channel()
+		subscription, err := bus.SubscribeResumable(context.Background(), services.GetBus(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelection, documentId), "")
+		if err != nil {
+			log.Println("subscribe fail", err)
+			return
+		}
+		defer subscription.Close()
+		// 订阅后再发送快照，快照之后的变化由订阅补上
+		serv.sendRoster()
+		channel := subscription.Channel()
 		for {
 			select {
 			case v, ok := <-channel:
 				if !ok {
-					break
+					return
 				}
 				serv.send(v.Payload)
 			case <-serv.quit:
@@ -139,6 +174,10 @@ func (serv *selectionServe) start(documentId string) {
 	}()
 }
 
+func (serv *selectionServe) setPermType(permType models.PermType) {
+	serv.permType.Store(uint32(permType))
+}
+
 func (serv *selectionServe) close() {
 	userIdStr := (serv.userId)
 	documentId := (serv.documentId)
@@ -146,9 +185,10 @@ func (serv *selectionServe) close() {
 		Type:   DocSelectionOpTypeExit,
 		UserId: userIdStr,
 	}
+	serv.follow("")
 	if data, err := json.Marshal(docSelectionOpData); err == nil {
-		serv.redis.Client.HDel(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelectionData, documentId), userIdStr)
-		serv.redis.Client.Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelection, documentId), string(data))
+		deleteSelectionData(serv.redis, documentId, userIdStr)
+		services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelection, documentId), string(data))
 	}
 	close(serv.quit)
 }
@@ -167,8 +207,15 @@ func (serv *selectionServe) handle(data *TransData, binaryData *([]byte)) {
 		} else {
 			log.Println(msg)
 		}
-		_ = serv.ws.WriteJSON(serverData)
+		_ = serv.ws.SendJSON(serverData)
 	}
+	followData := FollowData{}
+	if err := json.Unmarshal([]byte(data.Data), &followData); err == nil && followData.FollowUserId != nil {
+		serv.follow(*followData.FollowUserId)
+		_ = serv.ws.SendJSON(serverData)
+		return
+	}
+
 	selectionData := &DocSelectionData{}
 	if err := json.Unmarshal([]byte(data.Data), selectionData); err != nil {
 		msgErr("document selection数据解码错误", &serverData, &err)
@@ -176,24 +223,41 @@ func (serv *selectionServe) handle(data *TransData, binaryData *([]byte)) {
 	}
 
 	selectionData.UserId = userIdStr
-	selectionData.Permission = serv.permType
+	selectionData.Permission = models.PermType(serv.permType.Load())
 
 	selectionData.User = serv.user
 	selectionData.EnterTime = serv.enterTime
 	selectionDataJson, _ := json.Marshal(selectionData)
+	viewport := selectionData.Viewport
+	// 视口变化频繁，只发给跟随者
+	broadcastData := *selectionData
+	broadcastData.Viewport = nil
 	docSelectionOpData := &DocSelectionOpData{
 		Type:   DocSelectionOpTypeUpdate,
 		UserId: userIdStr,
-		Data:   selectionData,
+		Data:   &broadcastData,
 	}
 	if docSelectionOpDataJson, err := json.Marshal(docSelectionOpData); err != nil {
 		msgErr("document selection数据解码错误", &serverData, &err)
 		return
 	} else {
-		serv.redis.Client.HSet(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelectionData, documentId), userIdStr, string(selectionDataJson))
-		serv.redis.Client.Expire(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelectionData, documentId), time.Hour*1)
-		serv.redis.Client.Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelection, documentId), string(docSelectionOpDataJson))
-		serv.ws.WriteJSON(serverData)
+		saveSelectionData(serv.redis, documentId, userIdStr, string(selectionDataJson))
+		services.GetBus().Publish(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentSelection, documentId), string(docSelectionOpDataJson))
+		if viewport != nil {
+			viewportOpData := &DocSelectionOpData{
+				Type:   DocSelectionOpTypeViewport,
+				UserId: userIdStr,
+				Data: &DocSelectionData{
+					SelectPageId: selectionData.SelectPageId,
+					UserId:       userIdStr,
+					Viewport:     viewport,
+				},
+			}
+			if viewportOpDataJson, err := json.Marshal(viewportOpData); err == nil {
+				services.GetBus().Publish(context.Background(), serv.viewportChannel(userIdStr), string(viewportOpDataJson))
+			}
+		}
+		serv.ws.SendJSON(serverData)
 	}
 }
 
@@ -208,8 +272,109 @@ func (serv *selectionServe) send(data string) {
 		Type:   DataTypes_Selection,
 		DataId: sid,
 		Data:   data,
+		DocId:  serv.documentId,
+	}
+	// 同一文档中同一用户的选区只需发送最新的，客户端跟不上时合并
+	opData := DocSelectionOpData{}
+	var err error
+	if json.Unmarshal([]byte(data), &opData) == nil && opData.Type == DocSelectionOpTypeUpdate && opData.UserId != "" {
+		err = serv.ws.SendJSONCoalesce(DataTypes_Selection+":"+serv.documentId+":"+opData.UserId, &serverData)
+	} else if opData.Type == DocSelectionOpTypeViewport {
+		err = serv.ws.SendJSONCoalesce(DataTypes_Selection+":"+serv.documentId+":viewport", &serverData)
+	} else {
+		err = serv.ws.SendJSON(&serverData)
 	}
-	if err := serv.ws.WriteJSONLock(true, &serverData); err != nil {
+	if err != nil {
 		log.Println("selection, send data fail", err)
 	}
 }
+
+// 发送当前在线用户的选区，不含自己
+func (serv *selectionServe) sendRoster() {
+	roster := []*DocSelectionData{}
+	for userId, data := range listSelectionData(serv.redis, serv.documentId) {
+		if userId == serv.userId {
+			continue
+		}
+		selectionData := &DocSelectionData{}
+		if err := json.Unmarshal([]byte(data), selectionData); err != nil {
+			log.Println("selection, 选区数据错误", err)
+			continue
+		}
+		selectionData.Viewport = nil
+		roster = append(roster, selectionData)
+	}
+	data, err := json.Marshal(&DocSelectionOpData{
+		Type:   DocSelectionOpTypeRoster,
+		UserId: serv.userId,
+		Roster: roster,
+	})
+	if err != nil {
+		log.Println("selection, roster编码错误", err)
+		return
+	}
+	serv.send(string(data))
+}
+
+func (serv *selectionServe) viewportChannel(userId string) string {
+	return fmt.Sprintf("%s%s:%s", common.RedisKeyDocumentViewport, serv.documentId, userId)
+}
+
+// 跟随userId，之后该用户的页面及视口变化都会发给当前连接，userId为空时取消跟随
+func (serv *selectionServe) follow(userId string) {
+	serv.followMutex.Lock()
+	defer serv.followMutex.Unlock()
+	if serv.followQuit != nil {
+		close(serv.followQuit)
+		serv.followQuit = nil
+	}
+	if userId == serv.userId {
+		userId = ""
+	}
+	serv.followUserId = userId
+	if userId == "" {
+		return
+	}
+	quit := make(chan struct{})
+	serv.followQuit = quit
+	go func() {
+		subscription, err := bus.SubscribeResumable(context.Background(), services.GetBus(), serv.viewportChannel(userId), "")
+		if err != nil {
+			log.Println("subscribe fail", err)
+			return
+		}
+		defer subscription.Close()
+		// 先跳到被跟随者当前的视口
+		if data, ok := getSelectionData(serv.redis, serv.documentId, userId); ok {
+			selectionData := &DocSelectionData{}
+			if err := json.Unmarshal([]byte(data), selectionData); err == nil {
+				viewportOpData := &DocSelectionOpData{
+					Type:   DocSelectionOpTypeViewport,
+					UserId: userId,
+					Data: &DocSelectionData{
+						SelectPageId: selectionData.SelectPageId,
+						UserId:       userId,
+						Viewport:     selectionData.Viewport,
+					},
+				}
+				if data, err := json.Marshal(viewportOpData); err == nil {
+					serv.send(string(data))
+				}
+			}
+		}
+		channel := subscription.Channel()
+		for {
+			select {
+			case v, ok := <-channel:
+				if !ok {
+					return
+				}
+				serv.send(v.Payload)
+			case <-quit:
+				return
+			case <-serv.quit:
+				return
+			}
+		}
+	}()
+}
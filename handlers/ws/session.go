@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type ResumeData struct {
+	SessionToken string `json:"session_token"`
+}
+
+// 连接断开后保留的会话，在宽限期内可由新连接恢复
+// 会话只保存在当前实例，其它实例上恢复会失败，客户端需重新bind/start
+type parkedSession struct {
+	client *WSClient
+	timer  *time.Timer
+}
+
+var (
+	parkedSessions      = map[string]*parkedSession{}
+	parkedSessionsMutex sync.Mutex
+)
+
+// 保留会话，宽限期过后关闭各serve
+func parkSession(c *WSClient, grace time.Duration) bool {
+	if c.sessionToken == "" || grace <= 0 || !c.ws.Suspend() {
+		return false
+	}
+	token := c.sessionToken
+	parkedSessionsMutex.Lock()
+	defer parkedSessionsMutex.Unlock()
+	parkedSessions[token] = &parkedSession{
+		client: c,
+		timer: time.AfterFunc(grace, func() {
+			if session := takeSession(token); session != nil {
+				log.Println("ws会话过期", token)
+				session.closeServes()
+				session.ws.Release()
+			}
+		}),
+	}
+	return true
+}
+
+// 取出会话，同一会话只能被取出一次
+func takeSession(token string) *WSClient {
+	parkedSessionsMutex.Lock()
+	defer parkedSessionsMutex.Unlock()
+	session, ok := parkedSessions[token]
+	if !ok {
+		return nil
+	}
+	delete(parkedSessions, token)
+	session.timer.Stop()
+	return session.client
+}
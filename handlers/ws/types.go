@@ -50,9 +50,13 @@ const (
 	DataTypes_Resource        = "resource"
 	DataTypes_Thumbnail       = "thumbnail"
 	DataTypes_Selection       = "selection"
+	DataTypes_Broadcast       = "broadcast"
 	DataTypes_DocUpload       = "docupload"
 	DataTypes_Bind            = "bind"
 	DataTypes_Start           = "start"
+	DataTypes_Unbind          = "unbind"
+	DataTypes_Permission      = "permission"
+	DataTypes_Resume          = "resume"
 	DataTypes_Heartbeat       = "heartbeat"
 	DataTypes_GenerateVersion = "generateVersion"
 )
@@ -64,6 +68,7 @@ type TransData struct {
 	Data   string `json:"data,omitempty"`
 	Msg    string `json:"msg,omitempty"`
 	Code   int32  `json:"code,omitempty"`
+	DocId  string `json:"doc_id,omitempty"` // 消息所属的文档，为空时为第一个绑定的文档
 }
 
 // type TunnelDataType uint8
@@ -17,7 +17,7 @@ import (
 
 	"kcaitech.com/kcserver/common"
 	"kcaitech.com/kcserver/models"
-	"kcaitech.com/kcserver/providers/redis"
+	"kcaitech.com/kcserver/providers/bus"
 	"kcaitech.com/kcserver/services"
 	"kcaitech.com/kcserver/utils/websocket"
 )
@@ -26,8 +26,8 @@ type VersionServe struct {
 	ws   *websocket.Ws
 	quit chan struct{}
 	// isready bool
-	genSId func() string
-	redis  *redis.RedisDB
+	genSId     func() string
+	documentId string
 }
 
 func NewVersionServe(ws *websocket.Ws, userId string, documentId string, genSId func() string) *VersionServe {
@@ -41,9 +41,9 @@ func NewVersionServe(ws *websocket.Ws, userId string, documentId string, genSId
 	serv := VersionServe{
 		ws: ws,
 		// isready: false,
-		genSId: genSId,
-		quit:   make(chan struct{}),
-		redis:  services.GetRedisDB(),
+		genSId:     genSId,
+		documentId: documentId,
+		quit:       make(chan struct{}),
 	}
 
 	serv.start(documentId)
@@ -57,14 +57,18 @@ func (serv *VersionServe) start(documentId string) {
 	// 监控评论变化
 	go func() {
 		// defer tunnelServer.Close()
-		pubsub := serv.redis.Client.Subscribe(context.Background(), fmt.Sprintf("%s%s", common.RedisKeyDocumentVersion, documentId))
-		defer pubsub.Close()
-		channel := pubsub.Channel()
+		subscription, err := bus.SubscribeResumable(context.Background(), services.GetBus(), fmt.Sprintf("%s%s", common.RedisKeyDocumentVersion, documentId), "")
+		if err != nil {
+			log.Println("subscribe fail", err)
+			return
+		}
+		defer subscription.Close()
+		channel := subscription.Channel()
 		for {
 			select {
 			case v, ok := <-channel:
 				if !ok {
-					break
+					return
 				}
 				serv.send(v.Payload)
 			case <-serv.quit:
@@ -87,8 +91,9 @@ func (serv *VersionServe) send(data string) {
 		Type:   DataTypes_GenerateVersion,
 		DataId: sid,
 		Data:   data,
+		DocId:  serv.documentId,
 	}
-	if err := serv.ws.WriteJSONLock(true, &serverData); err != nil {
+	if err := serv.ws.SendJSON(&serverData); err != nil {
 		log.Println("version, send data fail", err)
 		return
 	}
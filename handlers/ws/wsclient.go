@@ -19,7 +19,9 @@ import (
 	"net/http"
 	"reflect"
 	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"kcaitech.com/kcserver/handlers/common"
 	"kcaitech.com/kcserver/models"
 	"kcaitech.com/kcserver/services"
@@ -58,11 +60,14 @@ func decodeBinaryMessage(data []byte) (string, []byte, error) {
 type BindData struct {
 	DocumentId string `json:"document_id"`
 	// VersionId  string `json:"version_id"`
-	Perm string `json:"perm_type,omitempty"`
+	Perm        string `json:"perm_type,omitempty"`
+	Compression bool   `json:"compression,omitempty"` // 开启permessage-deflate压缩
+	Encoding    string `json:"encoding,omitempty"`    // cmds编码：json msgpack cbor
 }
 
 type StartData struct {
-	LastCmdVersion uint `json:"last_cmd_version,omitempty"`
+	LastCmdVersion uint   `json:"last_cmd_version,omitempty"`
+	Encoding       string `json:"encoding,omitempty"` // 覆盖bind时的编码
 }
 
 type ServeFace interface {
@@ -79,13 +84,16 @@ func isInterfaceNil(i interface{}) bool {
 }
 
 type WSClient struct {
-	serveMap   map[string]ServeFace
-	ws         *websocket.Ws
-	token      string
-	genSId     func() string
-	userId     string
-	documentId string
-	versionId  string
+	serveMap map[string]ServeFace // 与文档无关的serve
+	ws       *websocket.Ws
+	token    string
+	genSId   func() string
+	userId   string
+
+	channels          map[string]*docChannel // 已绑定的文档
+	defaultDocumentId string                 // 未指定doc_id的消息发往该文档，兼容单文档的客户端
+
+	sessionToken string // start时生成，断线重连时用于恢复会话
 
 	serverSideWs bool
 }
@@ -102,6 +110,7 @@ func NewWSClient(ws *websocket.Ws, token string, userId string, serverSideWs boo
 		userId:       userId,
 		genSId:       genSId,
 		serveMap:     map[string]ServeFace{},
+		channels:     map[string]*docChannel{},
 		serverSideWs: serverSideWs,
 	}
 }
@@ -113,7 +122,7 @@ func (c *WSClient) msgErr(msg string, serverData *TransData, err *error) {
 	} else {
 		log.Println(msg)
 	}
-	_ = c.ws.WriteJSON(serverData)
+	_ = c.ws.SendJSON(serverData)
 }
 
 func (c *WSClient) msgErrWithCode(msg string, serverData *TransData, err *error, errCode int) {
@@ -124,19 +133,20 @@ func (c *WSClient) msgErrWithCode(msg string, serverData *TransData, err *error,
 	} else {
 		log.Println(msg)
 	}
-	_ = c.ws.WriteJSON(serverData)
+	_ = c.ws.SendJSON(serverData)
 }
 
 func (c *WSClient) bindServe(t string, s ServeFace) {
-	old := c.serveMap[t]
-	if old != nil {
-		(old).close()
-	}
-	if !isInterfaceNil(s) {
-		c.serveMap[t] = s
-	} else {
-		delete(c.serveMap, t)
+	bindServe(c.serveMap, t, s)
+}
+
+// 消息所属的文档
+func (c *WSClient) getChannel(clientData *TransData) *docChannel {
+	documentId := clientData.DocId
+	if documentId == "" {
+		documentId = c.defaultDocumentId
 	}
+	return c.channels[documentId]
 }
 
 func (c *WSClient) handleBind(clientData *TransData) {
@@ -156,6 +166,10 @@ func (c *WSClient) handleBind(clientData *TransData) {
 		c.msgErr("wrong document id", &serverData, nil)
 		return
 	}
+	if _, ok := c.channels[documentId]; !ok && len(c.channels) >= maxDocChannels {
+		c.msgErr("too many documents", &serverData, nil)
+		return
+	}
 
 	permType := models.PermType(str.DefaultToInt(bindData.Perm, 0))
 	if permType < models.PermTypeReadOnly || permType > models.PermTypeEditable {
@@ -180,21 +194,35 @@ func (c *WSClient) handleBind(clientData *TransData) {
 		return
 	}
 
+	compression := bindData.Compression && c.ws.EnableCompression(compressThreshold, 0)
+	encoding := parseEncoding(bindData.Encoding)
+
 	retstr, err := json.Marshal(&map[string]any{
-		"doc_info":   docInfo,
-		"access_key": accessKey,
+		"doc_info":    docInfo,
+		"access_key":  accessKey,
+		"compression": compression,
+		"encoding":    encoding,
 	})
 	if err != nil {
 		c.msgErr("unknow", &serverData, nil)
 		return
 	}
 
-	c.documentId = documentId
-	c.versionId = docInfo.Document.VersionId
+	// 重复bind时重新建立该文档的serve
+	if old, ok := c.channels[documentId]; ok {
+		old.close()
+	}
+	ch := newDocChannel(documentId, docInfo.Document.VersionId, encoding)
+	ch.watchPermission(c.ws, c.userId, c.genSId)
+	c.channels[documentId] = ch
+	if c.defaultDocumentId == "" {
+		c.defaultDocumentId = documentId
+	}
 
+	serverData.DocId = documentId
 	serverData.Data = string(retstr)
 	// send back message
-	c.ws.WriteJSON(serverData)
+	c.ws.SendJSON(serverData)
 }
 
 func (c *WSClient) handleStart(clientData *TransData) {
@@ -202,10 +230,16 @@ func (c *WSClient) handleStart(clientData *TransData) {
 	serverData.Type = clientData.Type
 	serverData.DataId = clientData.DataId
 
-	if c.documentId == "" {
+	ch := c.getChannel(clientData)
+	if ch == nil {
 		c.msgErr("not bind document", &serverData, nil)
 		return
 	}
+	serverData.DocId = ch.documentId
+	if ch.isRevoked() {
+		c.msgErrWithCode("permission revoked", &serverData, nil, http.StatusForbidden)
+		return
+	}
 
 	startdata := StartData{}
 	err := json.Unmarshal([]byte(clientData.Data), &startdata)
@@ -215,26 +249,162 @@ func (c *WSClient) handleStart(clientData *TransData) {
 	}
 
 	lastCmdVersion := startdata.LastCmdVersion
+	if startdata.Encoding != "" {
+		ch.encoding = parseEncoding(startdata.Encoding)
+	}
 
-	log.Println("LastCmdVersion", startdata.LastCmdVersion, lastCmdVersion)
+	log.Println("LastCmdVersion", ch.documentId, startdata.LastCmdVersion, lastCmdVersion)
+	opServe := NewOpServe(c.ws, c.userId, ch.documentId, ch.versionId, lastCmdVersion, ch.encoding, c.genSId) // todo VersionId
+	if opServe == nil {
+		// 文档或权限校验失败，关闭该文档已绑定的serve
+		ch.closeServes()
+		c.msgErr("start failed", &serverData, nil)
+		return
+	}
 	// bind comment
-	commentServe := NewCommentServe(c.ws, c.userId, c.documentId, c.genSId)
-	c.bindServe(DataTypes_Comment, commentServe)
-	opServe := NewOpServe(c.ws, c.userId, c.documentId, c.versionId, lastCmdVersion, c.genSId) // todo VersionId
-	c.bindServe(DataTypes_Op, opServe)
-	resourceServe := NewResourceServe(c.ws, c.userId, c.documentId)
-	c.bindServe(DataTypes_Resource, resourceServe)
-	thumbnailServe := NewThumbnailServe(c.ws, c.userId, c.documentId)
-	c.bindServe(DataTypes_Thumbnail, thumbnailServe)
-	selectionServe := NewSelectionServe(c.ws, c.token, c.userId, c.documentId, c.genSId)
-	c.bindServe(DataTypes_Selection, selectionServe)
-	versionServe := NewVersionServe(c.ws, c.userId, c.documentId, c.genSId)
-	c.bindServe(DataTypes_GenerateVersion, versionServe)
-
-	c.ws.WriteJSON(serverData)
+	commentServe := NewCommentServe(c.ws, c.token, c.userId, ch.documentId, c.genSId)
+	ch.bindServe(DataTypes_Comment, commentServe)
+	ch.bindServe(DataTypes_Op, opServe)
+	resourceServe := NewResourceServe(c.ws, c.userId, ch.documentId)
+	ch.bindServe(DataTypes_Resource, resourceServe)
+	thumbnailServe := NewThumbnailServe(c.ws, c.userId, ch.documentId)
+	ch.bindServe(DataTypes_Thumbnail, thumbnailServe)
+	selectionServe := NewSelectionServe(c.ws, c.token, c.userId, ch.documentId, c.genSId)
+	ch.bindServe(DataTypes_Selection, selectionServe)
+	broadcastServe := NewBroadcastServe(c.ws, c.userId, ch.documentId, c.genSId)
+	ch.bindServe(DataTypes_Broadcast, broadcastServe)
+	versionServe := NewVersionServe(c.ws, c.userId, ch.documentId, c.genSId)
+	ch.bindServe(DataTypes_GenerateVersion, versionServe)
+
+	// 一个连接只有一个会话，包含所有已绑定的文档
+	if c.sessionToken == "" {
+		c.sessionToken = uuid.NewString()
+	}
+	if retstr, err := json.Marshal(map[string]any{"encoding": ch.encoding, "session_token": c.sessionToken}); err == nil {
+		serverData.Data = string(retstr)
+	}
+	c.ws.SendJSON(serverData)
+}
+
+// 解除文档绑定，关闭该文档的serve
+func (c *WSClient) handleUnbind(clientData *TransData) {
+	serverData := TransData{}
+	serverData.Type = clientData.Type
+	serverData.DataId = clientData.DataId
+
+	unbindData := UnbindData{}
+	if clientData.Data != "" {
+		if err := json.Unmarshal([]byte(clientData.Data), &unbindData); err != nil {
+			c.msgErr("wrong unbind struct", &serverData, nil)
+			return
+		}
+	}
+	if unbindData.DocumentId != "" {
+		clientData.DocId = unbindData.DocumentId
+	}
+	ch := c.getChannel(clientData)
+	if ch == nil {
+		c.msgErr("not bind document", &serverData, nil)
+		return
+	}
+	ch.close()
+	delete(c.channels, ch.documentId)
+	if c.defaultDocumentId == ch.documentId {
+		c.defaultDocumentId = ""
+	}
+	serverData.DocId = ch.documentId
+	c.ws.SendJSON(serverData)
+}
+
+// 恢复断开前的会话，沿用已绑定的文档及各serve，断开期间未发送的消息随后补发
+func (c *WSClient) handleResume(clientData *TransData) {
+	serverData := TransData{}
+	serverData.Type = clientData.Type
+	serverData.DataId = clientData.DataId
+
+	resumeData := ResumeData{}
+	if err := json.Unmarshal([]byte(clientData.Data), &resumeData); err != nil || resumeData.SessionToken == "" {
+		c.msgErr("wrong resume struct", &serverData, nil)
+		return
+	}
+	if len(c.channels) > 0 {
+		c.msgErr("already bind document", &serverData, nil)
+		return
+	}
+	old := takeSession(resumeData.SessionToken)
+	if old != nil && old.userId != c.userId {
+		old.closeServes()
+		old.ws.Release()
+		old = nil
+	}
+	if old == nil {
+		c.msgErrWithCode("session expired", &serverData, nil, http.StatusNotFound)
+		return
+	}
+	if err := c.ws.TakeOver(old.ws); err != nil {
+		old.closeServes()
+		old.ws.Release()
+		c.msgErrWithCode("session expired", &serverData, &err, http.StatusNotFound)
+		return
+	}
+
+	c.channels = old.channels
+	c.defaultDocumentId = old.defaultDocumentId
+	c.genSId = old.genSId
+	c.sessionToken = old.sessionToken
+	old.channels = map[string]*docChannel{}
+	// 上传等与连接绑定的serve不恢复
+	old.closeServes()
+
+	documentIds := make([]string, 0, len(c.channels))
+	for documentId := range c.channels {
+		documentIds = append(documentIds, documentId)
+	}
+	if retstr, err := json.Marshal(map[string]any{
+		"document_ids":  documentIds,
+		"document_id":   c.defaultDocumentId,
+		"session_token": c.sessionToken,
+	}); err == nil {
+		serverData.Data = string(retstr)
+	}
+	c.ws.SendJSON(serverData)
+}
+
+func (c *WSClient) closeServes() {
+	for _, h := range c.serveMap {
+		if nil != h {
+			h.close()
+		}
+	}
+	for _, ch := range c.channels {
+		ch.close()
+	}
+}
+
+const (
+	defaultPingInterval = 20 * time.Second
+	defaultPongTimeout  = 60 * time.Second
+)
+
+func (c *WSClient) startKeepalive() {
+	config := services.GetConfig().WebSocket
+	keepalive := websocket.KeepaliveConfig{
+		PingInterval: time.Duration(config.PingInterval) * time.Second,
+		PongTimeout:  time.Duration(config.PongTimeout) * time.Second,
+		IdleTimeout:  time.Duration(config.IdleTimeout) * time.Second,
+	}
+	if keepalive.PingInterval <= 0 {
+		keepalive.PingInterval = defaultPingInterval
+	}
+	if keepalive.PongTimeout <= keepalive.PingInterval {
+		keepalive.PongTimeout = max(defaultPongTimeout, keepalive.PingInterval*3)
+	}
+	c.ws.StartKeepalive(keepalive)
 }
 
 func (c *WSClient) Serve() {
+	// 连接断开后由下面的defer关闭各serve，清理选区等状态
+	c.startKeepalive()
 
 	// doc upload
 	docUploadServe := NewDocUploadServe(c.ws, c.userId)
@@ -242,11 +412,13 @@ func (c *WSClient) Serve() {
 
 	// close handlers
 	defer (func() {
-		for _, h := range c.serveMap {
-			if nil != h {
-				h.close()
-			}
+		// 已start的会话保留一段时间，等待客户端重连恢复
+		grace := time.Duration(services.GetConfig().WebSocket.ResumeGrace) * time.Second
+		if parkSession(c, grace) {
+			log.Println("ws会话保留", c.sessionToken, len(c.channels))
+			return
 		}
+		c.closeServes()
 	})()
 
 	for {
@@ -298,9 +470,11 @@ func (c *WSClient) Serve() {
 		log.Println("ws receive msg:", clientData.Type, mt)
 
 		if clientData.Type == DataTypes_Heartbeat {
-			_ = c.ws.WriteJSON(&serverData)
+			// 心跳不计入业务消息
+			_ = c.ws.SendJSON(&serverData)
 			continue
 		}
+		c.ws.MarkActive()
 		if clientData.Type == DataTypes_Bind {
 			// permType := models.PermType(str.DefaultToInt(c.Query("perm_type"), 0))
 			c.handleBind(&clientData)
@@ -310,8 +484,18 @@ func (c *WSClient) Serve() {
 			c.handleStart(&clientData)
 			continue
 		}
-		serve := c.serveMap[clientData.Type]
-		if serve != nil {
+		if clientData.Type == DataTypes_Unbind {
+			c.handleUnbind(&clientData)
+			continue
+		}
+		if clientData.Type == DataTypes_Resume {
+			c.handleResume(&clientData)
+			continue
+		}
+		if ch := c.getChannel(&clientData); ch != nil && ch.handle(clientData.Type, &clientData, binaryData) {
+			continue
+		}
+		if serve := c.serveMap[clientData.Type]; serve != nil {
 			serve.handle(&clientData, binaryData)
 		} else {
 			c.msgErr("no bind handler", &serverData, nil)
@@ -180,3 +180,24 @@ func BenchmarkIsInterfaceNil(b *testing.B) {
 		_ = isInterfaceNil(interfaceServe)
 	}
 }
+
+// 测试多文档时消息按doc_id分发
+func TestGetChannel(t *testing.T) {
+	client := &WSClient{
+		serveMap: make(map[string]ServeFace),
+		channels: map[string]*docChannel{},
+	}
+	client.channels["d1"] = newDocChannel("d1", "", EncodingJson)
+	client.channels["d2"] = newDocChannel("d2", "", EncodingJson)
+	client.defaultDocumentId = "d1"
+
+	if ch := client.getChannel(&TransData{}); ch == nil || ch.documentId != "d1" {
+		t.Error("未指定doc_id时应发往第一个绑定的文档")
+	}
+	if ch := client.getChannel(&TransData{DocId: "d2"}); ch == nil || ch.documentId != "d2" {
+		t.Error("应按doc_id发往对应文档")
+	}
+	if ch := client.getChannel(&TransData{DocId: "d3"}); ch != nil {
+		t.Error("未绑定的文档应返回nil")
+	}
+}
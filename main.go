@@ -11,6 +11,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -18,6 +19,7 @@ import (
 	"github.com/gin-gonic/gin"
 	api "kcaitech.com/kcserver/api"
 	config "kcaitech.com/kcserver/config"
+	"kcaitech.com/kcserver/handlers/common"
 	"kcaitech.com/kcserver/middlewares"
 	"kcaitech.com/kcserver/services"
 )
@@ -58,6 +60,19 @@ func initServices(configFile string) {
 	// return conf
 }
 
+// 存储对账，执行完后退出
+func runReconcile(mode string) {
+	if mode != "report" && mode != "fix" {
+		log.Fatalf("reconcile参数错误：%s", mode)
+	}
+	result, err := common.ReconcileStorage(mode == "fix")
+	if err != nil {
+		log.Fatalf("存储对账失败: %v", err)
+	}
+	data, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(data))
+}
+
 const defaultConfigFile = "config/config.yaml"
 const defaultPort = 80
 const defaultWebFilePath = "/app/html"
@@ -66,8 +81,17 @@ func main() {
 	configFile := flag.String("config", defaultConfigFile, "config file")
 	port := flag.Int("port", defaultPort, "port")
 	webFilePath := flag.String("web", defaultWebFilePath, "web file path")
+	reconcile := flag.String("reconcile", "", "storage reconciliation, report | fix")
 	flag.Parse()
 	initServices(*configFile)
+	if *reconcile != "" {
+		runReconcile(*reconcile)
+		return
+	}
+	common.RunVersioningScheduler(services.GetConfig())
+	go common.RunCmdCompaction(services.GetConfig())
+	go common.RunDocumentPurge()
+	go common.RunRecycleBinExpiry(services.GetConfig())
 	start(func(router *gin.Engine) {
 		api.LoadRoutes(router, *webFilePath)
 	}, *port)
@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// 服务端无法应用的op，需要交给version server处理
+var ErrUnsupportedOp = errors.New("unsupported op")
+
+// 文档数据，用于在服务端应用cmd生成快照
+type DocumentData struct {
+	Meta  map[string]any
+	Pages map[string]map[string]any // 页面id -> 页面数据
+}
+
+// 按顺序应用cmds
+func (d *DocumentData) ApplyCmds(cmdItems []CmdItem) error {
+	for _, item := range cmdItems {
+		for _, op := range item.Cmd.Ops {
+			if err := d.ApplyOp(op); err != nil {
+				return fmt.Errorf("cmd %d: %w", item.VerId, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 目前只处理页面内的属性设置（Idset），其它类型及缺少type的op都返回ErrUnsupportedOp
+func (d *DocumentData) ApplyOp(op bson.M) error {
+	if GetOpType(op) != OpTypeIdset {
+		return ErrUnsupportedOp
+	}
+	// mongo中的嵌套类型统一转为json类型
+	normalized, err := normalizeOp(op)
+	if err != nil {
+		return err
+	}
+	path := opPath(normalized)
+	if len(path) == 0 {
+		return ErrUnsupportedOp
+	}
+	// 页面的增删需要同时修改document-meta，交给version server处理
+	page, ok := d.Pages[path[0]]
+	if !ok {
+		return ErrUnsupportedOp
+	}
+	key, ok := normalized["id"].(string)
+	if !ok || key == "" {
+		return ErrUnsupportedOp
+	}
+	target, ok := resolvePath(page, path[1:]).(map[string]any)
+	if !ok {
+		return ErrUnsupportedOp
+	}
+	if data, ok := normalized["data"]; ok && data != nil {
+		target[key] = data
+	} else {
+		delete(target, key)
+	}
+	return nil
+}
+
+func normalizeOp(op bson.M) (map[string]any, error) {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return nil, err
+	}
+	normalized := map[string]any{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
+
+func opPath(op map[string]any) []string {
+	p, ok := op["path"].([]any)
+	if !ok {
+		return nil
+	}
+	path := make([]string, 0, len(p))
+	for _, seg := range p {
+		s, ok := seg.(string)
+		if !ok {
+			return nil
+		}
+		path = append(path, s)
+	}
+	return path
+}
+
+// 按路径逐级查找对象，数组中按id或下标匹配
+func resolvePath(node any, path []string) any {
+	for _, seg := range path {
+		node = resolveSegment(node, seg)
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+func resolveSegment(node any, seg string) any {
+	switch n := node.(type) {
+	case map[string]any:
+		if v, ok := n[seg]; ok {
+			return v
+		}
+	case []any:
+		for _, item := range n {
+			if m, ok := item.(map[string]any); ok && m["id"] == seg {
+				return m
+			}
+		}
+		if i, err := strconv.Atoi(seg); err == nil && i >= 0 && i < len(n) {
+			return n[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func testDocumentData() *DocumentData {
+	return &DocumentData{
+		Meta: map[string]any{"pagesList": []any{map[string]any{"id": "p1"}}},
+		Pages: map[string]map[string]any{
+			"p1": {
+				"id": "p1",
+				"childs": []any{
+					map[string]any{"id": "g1", "childs": []any{
+						map[string]any{"id": "s1", "name": "rect", "style": map[string]any{"fills": []any{}}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func TestApplyIdset(t *testing.T) {
+	d := testDocumentData()
+	path := bson.A{"p1", "childs", "g1", "childs", "s1"}
+	op := bson.M{"id": "name", "path": path, "type": int32(OpTypeIdset), "data": "ellipse"}
+	if err := d.ApplyOp(op); err != nil {
+		t.Fatal(err)
+	}
+	shape := resolvePath(d.Pages["p1"], []string{"childs", "g1", "childs", "s1"}).(map[string]any)
+	if shape["name"] != "ellipse" {
+		t.Errorf("期望ellipse，实际%v", shape["name"])
+	}
+
+	// data为空时删除属性
+	op = bson.M{"id": "name", "path": path, "type": int32(OpTypeIdset)}
+	if err := d.ApplyOp(op); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := shape["name"]; ok {
+		t.Error("属性应当被删除")
+	}
+}
+
+func TestApplyUnsupported(t *testing.T) {
+	d := testDocumentData()
+	if err := d.ApplyOp(textOp(ArrayOpTypeInsert, 0, 1)); !errors.Is(err, ErrUnsupportedOp) {
+		t.Errorf("文本op应当不支持，实际%v", err)
+	}
+	// 页面不存在
+	op := bson.M{"id": "name", "path": bson.A{"p2"}, "type": int32(OpTypeIdset), "data": "x"}
+	if err := d.ApplyOp(op); !errors.Is(err, ErrUnsupportedOp) {
+		t.Errorf("未知页面应当不支持，实际%v", err)
+	}
+	// 缺少type或type不是数字
+	for _, op := range []bson.M{
+		{"id": "name", "path": bson.A{"p1"}, "data": "x"},
+		{"id": "name", "path": bson.A{"p1"}, "type": "idset", "data": "x"},
+		{"id": "restore", "type": int32(OpTypeNone)},
+	} {
+		if err := d.ApplyOp(op); !errors.Is(err, ErrUnsupportedOp) {
+			t.Errorf("%v应当不支持，实际%v", op, err)
+		}
+	}
+	// 路径须逐级匹配，不按id跨层级查找
+	op = bson.M{"id": "name", "path": bson.A{"p1", "s1"}, "type": int32(OpTypeIdset), "data": "x"}
+	if err := d.ApplyOp(op); !errors.Is(err, ErrUnsupportedOp) {
+		t.Errorf("跨层级的路径应当不支持，实际%v", err)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package models
+
+import (
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// op类型，与前端coop保持一致
+type OpType int
+
+const (
+	OpTypeNone OpType = iota
+	OpTypeArray
+	OpTypeIdset
+	OpTypeCrdtArr
+	OpTypeCrdtTree
+)
+
+// 数组（文本）op的类型，对应op中的type1字段
+type ArrayOpType int
+
+const (
+	ArrayOpTypeNone ArrayOpType = iota
+	ArrayOpTypeInsert
+	ArrayOpTypeRemove
+	ArrayOpTypeAttr
+)
+
+// 数值可能来自json(float64)或mongo(int32/int64)
+func opInt(op bson.M, key string) (int, bool) {
+	switch v := op[key].(type) {
+	case int:
+		return v, true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case float32:
+		return int(v), true
+	}
+	return 0, false
+}
+
+func GetOpType(op bson.M) OpType {
+	t, _ := opInt(op, "type")
+	return OpType(t)
+}
+
+// op作用对象的路径
+func GetOpPath(op bson.M) string {
+	switch p := op["path"].(type) {
+	case []string:
+		return strings.Join(p, "/")
+	case []any:
+		segs := make([]string, 0, len(p))
+		for _, s := range p {
+			if str, ok := s.(string); ok {
+				segs = append(segs, str)
+			}
+		}
+		return strings.Join(segs, "/")
+	case bson.A:
+		return GetOpPath(bson.M{"path": []any(p)})
+	}
+	return ""
+}
+
+func getArrayOpType(op bson.M) ArrayOpType {
+	if GetOpType(op) != OpTypeArray {
+		return ArrayOpTypeNone
+	}
+	t, _ := opInt(op, "type1")
+	return ArrayOpType(t)
+}
+
+// 数组op的区间，insert的长度不占用原数组
+func textRange(op bson.M) (start int, length int, ok bool) {
+	start, ok1 := opInt(op, "start")
+	length, ok2 := opInt(op, "length")
+	if !ok1 || !ok2 {
+		return 0, 0, false
+	}
+	return start, length, true
+}
+
+func withRange(op bson.M, start int, length int) bson.M {
+	newOp := make(bson.M, len(op))
+	for k, v := range op {
+		newOp[k] = v
+	}
+	newOp["start"] = start
+	newOp["length"] = length
+	return newOp
+}
+
+// 将op变换到applied已执行之后的状态
+// op与applied基于同一状态，appliedFirst为true时同位置的插入applied排在前面
+// 返回空切片表示op已无效（如删除的内容已被删除）
+func TransformOp(op bson.M, applied bson.M, appliedFirst bool) []bson.M {
+	opType := getArrayOpType(op)
+	appliedType := getArrayOpType(applied)
+	if opType == ArrayOpTypeNone || appliedType == ArrayOpTypeNone || GetOpPath(op) != GetOpPath(applied) {
+		// 非数组op通过crdt或后写覆盖处理，不需要变换
+		return []bson.M{op}
+	}
+	start, length, ok := textRange(op)
+	aStart, aLength, aok := textRange(applied)
+	if !ok || !aok {
+		return []bson.M{op}
+	}
+
+	switch appliedType {
+	case ArrayOpTypeInsert:
+		if opType == ArrayOpTypeInsert {
+			if aStart < start || (aStart == start && appliedFirst) {
+				start += aLength
+			}
+			return []bson.M{withRange(op, start, length)}
+		}
+		if aStart <= start {
+			return []bson.M{withRange(op, start+aLength, length)}
+		}
+		if aStart >= start+length {
+			return []bson.M{op}
+		}
+		// 插入点在区间内部，拆分成两段，不影响新插入的内容
+		// 后段在前，执行前段时不需要再偏移
+		head := aStart - start
+		return []bson.M{
+			withRange(op, aStart+aLength, length-head),
+			withRange(op, start, head),
+		}
+	case ArrayOpTypeRemove:
+		aEnd := aStart + aLength
+		if opType == ArrayOpTypeInsert {
+			if start > aStart {
+				start -= min(aEnd, start) - aStart
+			}
+			return []bson.M{withRange(op, start, length)}
+		}
+		end := start + length
+		before := max(0, min(aEnd, start)-aStart)
+		within := max(0, min(end, aEnd)-max(start, aStart))
+		if length-within <= 0 {
+			return []bson.M{}
+		}
+		return []bson.M{withRange(op, start-before, length-within)}
+	}
+	// 属性修改不改变文本位置
+	return []bson.M{op}
+}
+
+// xs与ys基于同一状态，返回变换到ys之后的xs及变换到xs之后的ys
+func transformOpLists(xs []bson.M, ys []bson.M, ysFirst bool) ([]bson.M, []bson.M) {
+	if len(xs) == 0 || len(ys) == 0 {
+		return xs, ys
+	}
+	if len(xs) == 1 && len(ys) == 1 {
+		return TransformOp(xs[0], ys[0], ysFirst), TransformOp(ys[0], xs[0], !ysFirst)
+	}
+	if len(xs) > 1 {
+		// xs[1:]基于xs[0]之后的状态
+		head, ys1 := transformOpLists(xs[:1], ys, ysFirst)
+		tail, ys2 := transformOpLists(xs[1:], ys1, ysFirst)
+		return append(head, tail...), ys2
+	}
+	xs1, head := transformOpLists(xs, ys[:1], ysFirst)
+	xs2, tail := transformOpLists(xs1, ys[1:], ysFirst)
+	return xs2, append(head, tail...)
+}
+
+// 将基于旧版本的cmds变换到applied（中间已提交的cmd）之后
+func TransformCmds(cmds []Cmd, applied []CmdItem) []Cmd {
+	appliedOps := make([]bson.M, 0)
+	for _, item := range applied {
+		appliedOps = append(appliedOps, item.Cmd.Ops...)
+	}
+	result := make([]Cmd, 0, len(cmds))
+	for _, cmd := range cmds {
+		newCmd := cmd
+		// 后续cmd基于当前cmd，已提交的op也需要变换到当前cmd之后
+		newCmd.Ops, appliedOps = transformOpLists(cmd.Ops, appliedOps, true)
+		result = append(result, newCmd)
+	}
+	return result
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func textOp(t ArrayOpType, start, length int) bson.M {
+	return bson.M{"id": "op", "path": []any{"page", "shape", "text"}, "type": float64(OpTypeArray), "type1": float64(t), "start": float64(start), "length": float64(length)}
+}
+
+func checkRange(t *testing.T, op bson.M, start, length int) {
+	s, l, ok := textRange(op)
+	if !ok || s != start || l != length {
+		t.Errorf("期望(%d,%d)，实际(%d,%d)", start, length, s, l)
+	}
+}
+
+func TestTransformInsertInsert(t *testing.T) {
+	ops := TransformOp(textOp(ArrayOpTypeInsert, 5, 3), textOp(ArrayOpTypeInsert, 2, 4), true)
+	checkRange(t, ops[0], 9, 3)
+
+	// 同位置插入，已提交的在前
+	ops = TransformOp(textOp(ArrayOpTypeInsert, 5, 3), textOp(ArrayOpTypeInsert, 5, 4), true)
+	checkRange(t, ops[0], 9, 3)
+	ops = TransformOp(textOp(ArrayOpTypeInsert, 5, 3), textOp(ArrayOpTypeInsert, 5, 4), false)
+	checkRange(t, ops[0], 5, 3)
+}
+
+func TestTransformRemoveRemove(t *testing.T) {
+	// 部分重叠
+	ops := TransformOp(textOp(ArrayOpTypeRemove, 4, 6), textOp(ArrayOpTypeRemove, 2, 4), true)
+	checkRange(t, ops[0], 2, 4)
+
+	// 完全被删除
+	ops = TransformOp(textOp(ArrayOpTypeRemove, 4, 2), textOp(ArrayOpTypeRemove, 2, 6), true)
+	if len(ops) != 0 {
+		t.Errorf("已被删除的区间应当丢弃，实际%d", len(ops))
+	}
+}
+
+func TestTransformRemoveSplitByInsert(t *testing.T) {
+	ops := TransformOp(textOp(ArrayOpTypeRemove, 2, 6), textOp(ArrayOpTypeInsert, 4, 3), true)
+	if len(ops) != 2 {
+		t.Fatalf("应拆分为两段，实际%d", len(ops))
+	}
+	checkRange(t, ops[0], 7, 4)
+	checkRange(t, ops[1], 2, 2)
+}
+
+func TestTransformDifferentPath(t *testing.T) {
+	applied := textOp(ArrayOpTypeInsert, 0, 10)
+	applied["path"] = []any{"page", "other", "text"}
+	ops := TransformOp(textOp(ArrayOpTypeInsert, 5, 3), applied, true)
+	checkRange(t, ops[0], 5, 3)
+}
+
+func TestTransformCmds(t *testing.T) {
+	applied := []CmdItem{{Cmd: Cmd{Ops: []bson.M{textOp(ArrayOpTypeInsert, 0, 2)}}}}
+	cmds := []Cmd{
+		{Id: "a", Ops: []bson.M{textOp(ArrayOpTypeInsert, 3, 1)}},
+		{Id: "b", Ops: []bson.M{textOp(ArrayOpTypeRemove, 0, 4)}},
+	}
+	result := TransformCmds(cmds, applied)
+	checkRange(t, result[0].Ops[0], 5, 1)
+	// 在区间起点的插入不被删除
+	checkRange(t, result[1].Ops[0], 2, 4)
+}
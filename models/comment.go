@@ -182,6 +182,15 @@ func (s *UserCommentService) DeleteOne(_userComment *UserComment) (*mongodb.Dele
 	return s.Collection.DeleteOne(context.Background(), _userComment)
 }
 
+// 删除文档的所有评论
+func (s *UserCommentService) DeleteDocumentComments(documentId string) (int64, error) {
+	res, err := s.Collection.DeleteMany(context.Background(), bson.M{"document_id": documentId})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
+
 func (s *UserCommentService) Update(comment *UserComment, update interface{}) error {
 	_, err := s.Collection.UpdateByID(context.Background(), comment.Id, bson.M{"$set": update})
 	return err
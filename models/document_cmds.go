@@ -12,8 +12,11 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"time"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
@@ -49,6 +52,86 @@ func (cmdItem CmdItem) MarshalJSON() ([]byte, error) {
 	return MarshalJSON(cmdItem)
 }
 
+// 恢复版本的标记cmd，之前的cmd不再适用，客户端需要重新加载快照
+const CmdDescriptionRestore = "restore"
+
+func NewRestoreCmd(baseVer uint, versionId string, fromVersionId string) Cmd {
+	id := uuid.NewString()
+	now := time.Now().UnixMilli()
+	return Cmd{
+		Id:      id,
+		BaseVer: baseVer,
+		BatchId: id,
+		Ops: []bson.M{{
+			"id":              "restore",
+			"type":            OpTypeNone,
+			"version_id":      versionId,
+			"from_version_id": fromVersionId,
+		}},
+		Description: CmdDescriptionRestore,
+		Time:        now,
+		Posttime:    now,
+	}
+}
+
+func (cmd *Cmd) IsRestore() bool {
+	return cmd.Description == CmdDescriptionRestore
+}
+
+// 恢复标记cmd对应的新快照版本
+func (cmd *Cmd) RestoreVersionId() string {
+	if !cmd.IsRestore() || len(cmd.Ops) == 0 {
+		return ""
+	}
+	versionId, _ := cmd.Ops[0]["version_id"].(string)
+	return versionId
+}
+
+// 占位的空cmd，填补保存失败或被放弃的版本号，保证版本连续
+const CmdDescriptionGap = "gap"
+
+func NewGapCmdItem(documentId string, verId uint) CmdItem {
+	id := uuid.NewString()
+	now := time.Now().UnixMilli()
+	return CmdItem{
+		DocumentId: documentId,
+		Cmd: Cmd{
+			Id:          id,
+			BatchId:     id,
+			Ops:         []bson.M{},
+			Description: CmdDescriptionGap,
+			Time:        now,
+			Posttime:    now,
+		},
+		VerId:        verId,
+		BatchStartId: verId,
+		BatchLength:  1,
+	}
+}
+
+func (cmd *Cmd) IsGap() bool {
+	return cmd.Description == CmdDescriptionGap
+}
+
+// 按VerId排序的cmds中，[verStart, verEnd]范围内缺少的版本
+func MissingVerIds(cmdItems []CmdItem, verStart uint, verEnd uint) []uint {
+	missing := make([]uint, 0)
+	next := verStart
+	for _, item := range cmdItems {
+		if item.VerId < next || item.VerId > verEnd {
+			continue
+		}
+		for ; next < item.VerId; next++ {
+			missing = append(missing, next)
+		}
+		next = item.VerId + 1
+	}
+	for ; next <= verEnd && verEnd != math.MaxUint; next++ {
+		missing = append(missing, next)
+	}
+	return missing
+}
+
 func RenewCmdIds(cmdItems []CmdItem) {
 	// 要处理batch_id
 	batchIdMap := make(map[string]string)
@@ -76,8 +159,16 @@ func RenewCmdVerIds(cmdItems []CmdItem) {
 // service
 
 type CmdService struct {
-	MongoDB    *mongo.MongoDB
-	Collection *mongodb.Collection
+	MongoDB              *mongo.MongoDB
+	Collection           *mongodb.Collection
+	SeqCollection        *mongodb.Collection // 每个文档一条记录，用于原子分配VerId
+	ArchiveCollection    *mongodb.Collection // 已压缩的cmd
+	CompactionCollection *mongodb.Collection // 每个文档已压缩到的版本
+}
+
+type cmdVerSeq struct {
+	DocumentId string `bson:"_id"`
+	Seq        uint   `bson:"seq"`
 }
 
 func NewCmdService(mongoDB *mongo.MongoDB) *CmdService {
@@ -109,42 +200,112 @@ func NewCmdService(mongoDB *mongo.MongoDB) *CmdService {
 
 	// fmt.Printf("Created indexes %v\n", indexNames)
 	return &CmdService{
-		MongoDB:    mongoDB,
-		Collection: collection,
+		MongoDB:              mongoDB,
+		Collection:           collection,
+		SeqCollection:        mongoDB.DB.Collection("document_ver_seq"),
+		ArchiveCollection:    newCmdArchiveCollection(mongoDB),
+		CompactionCollection: mongoDB.DB.Collection("document_cmd_compaction"),
+	}
+}
+
+// 原子分配count个连续的VerId，返回第一个
+func (s *CmdService) AllocVerIds(documentId string, count uint) (uint, error) {
+	for i := 0; i < 2; i++ {
+		seq := cmdVerSeq{}
+		options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := s.SeqCollection.FindOneAndUpdate(context.Background(), bson.M{"_id": documentId}, bson.M{"$inc": bson.M{"seq": count}}, options).Decode(&seq)
+		if err == nil {
+			return seq.Seq - count + 1, nil
+		}
+		if err != mongodb.ErrNoDocuments {
+			return 0, err
+		}
+		// 首次分配，从已有的cmd初始化
+		if err := s.initVerSeq(documentId); err != nil {
+			return 0, err
+		}
+	}
+	return 0, errors.New("分配VerId失败")
+}
+
+func (s *CmdService) initVerSeq(documentId string) error {
+	lastVerId := uint(0)
+	cmdItem, err := s.GetLastCmdItem(documentId)
+	if err != nil {
+		return err
+	}
+	if cmdItem != nil {
+		lastVerId = cmdItem.VerId
+	}
+	// cmd可能已全部被压缩
+	archivedVer, err := s.GetArchivedVerId(documentId)
+	if err != nil {
+		return err
+	}
+	lastVerId = max(lastVerId, archivedVer)
+	if _, err := s.SeqCollection.InsertOne(context.Background(), cmdVerSeq{DocumentId: documentId, Seq: lastVerId}); err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
 	}
+	return nil
 }
 
-// 获取DocumentId的文档中VerId范围从start到end的CmdItem
+// 归还最后分配的未使用的VerId，期间有新的分配时无法归还
+func (s *CmdService) ReleaseVerIds(documentId string, start uint, count uint) (bool, error) {
+	if count == 0 {
+		return true, nil
+	}
+	res, err := s.SeqCollection.UpdateOne(context.Background(), bson.M{"_id": documentId, "seq": start + count - 1}, bson.M{"$inc": bson.M{"seq": -int64(count)}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// 序列落后于已有的cmd时（如VerId重复），同步到最后一个cmd
+func (s *CmdService) SyncVerSeq(documentId string) error {
+	cmdItem, err := s.GetLastCmdItem(documentId)
+	if err != nil || cmdItem == nil {
+		return err
+	}
+	_, err = s.SeqCollection.UpdateOne(context.Background(), bson.M{"_id": documentId, "seq": bson.M{"$lt": cmdItem.VerId}}, bson.M{"$set": bson.M{"seq": cmdItem.VerId}})
+	return err
+}
+
+// 获取DocumentId的文档中VerId范围从start到end的CmdItem，已压缩的部分从归档中获取
 func (s *CmdService) GetCmdItems(documentId string, verStart uint, verEnd uint) ([]CmdItem, error) {
 	filter := bson.M{"document_id": documentId, "ver_id": bson.M{"$gte": verStart, "$lte": verEnd}}
-	options := options.Find()
-	options.SetSort(bson.D{{Key: "ver_id", Value: 1}})
-	cursor, err := s.Collection.Find(context.Background(), filter, options)
+	cmdItems, err := findCmdItems(s.Collection, filter)
 	if err != nil {
 		return nil, err
 	}
-	var cmdItems []CmdItem = make([]CmdItem, 0)
-	if err := cursor.All(context.Background(), &cmdItems); err != nil {
-		return nil, err
-	}
-	return cmdItems, nil
+	return s.prependArchived(documentId, verStart, verEnd, cmdItems)
 }
 
-// 获取DocumentId的文档中VerId范围从start开始的所有的CmdItem
+// 获取DocumentId的文档中VerId范围从start开始的所有的CmdItem，已压缩的部分从归档中获取
 func (s *CmdService) GetCmdItemsFromStart(documentId string, verStart uint) ([]CmdItem, error) {
 	filter := bson.M{"document_id": documentId, "ver_id": bson.M{"$gte": verStart}}
-	options := options.Find()
-	options.SetSort(bson.D{{Key: "ver_id", Value: 1}})
-	cursor, err := s.Collection.Find(context.Background(), filter, options)
+	cmdItems, err := findCmdItems(s.Collection, filter)
 	if err != nil {
 		return nil, err
 	}
-	// 使用 make 创建非 nil 的空切片
-	cmdItems := make([]CmdItem, 0)
-	if err := cursor.All(context.Background(), &cmdItems); err != nil {
-		return nil, err
+	return s.prependArchived(documentId, verStart, math.MaxUint, cmdItems)
+}
+
+// 分块遍历verStart及之后的cmds，先遍历归档部分，避免一次加载到内存
+func (s *CmdService) IterCmdItemsFromStart(ctx context.Context, documentId string, verStart uint, chunkSize int, fn func([]CmdItem) error) error {
+	archivedVer, err := s.GetArchivedVerId(documentId)
+	if err != nil {
+		return err
 	}
-	return cmdItems, nil
+	if archivedVer >= verStart {
+		filter := bson.M{"document_id": documentId, "ver_id": bson.M{"$gte": verStart, "$lte": archivedVer}}
+		if err := iterCmdItems(ctx, s.ArchiveCollection, filter, chunkSize, fn); err != nil {
+			return err
+		}
+		verStart = archivedVer + 1
+	}
+	filter := bson.M{"document_id": documentId, "ver_id": bson.M{"$gte": verStart}}
+	return iterCmdItems(ctx, s.Collection, filter, chunkSize, fn)
 }
 
 func (s *CmdService) SaveCmdItems(cmdItems []CmdItem) (*mongodb.InsertManyResult, error) {
@@ -176,6 +337,119 @@ func (s *CmdService) GetLastCmdItem(documentId string) (*CmdItem, error) {
 	return nil, err
 }
 
+// 获取已保存的cmd，包括已压缩到归档中的
+func (s *CmdService) GetCmdsByIds(documentId string, cmdIds []string) ([]CmdItem, error) {
+	filter := bson.M{"document_id": documentId, "cmd_id": bson.M{"$in": cmdIds}}
+	archived, err := findCmdItems(s.ArchiveCollection, filter)
+	if err != nil {
+		return nil, err
+	}
+	cmdItems, err := findCmdItems(s.Collection, filter)
+	if err != nil {
+		return nil, err
+	}
+	return append(archived, cmdItems...), nil
+}
+
+const (
+	verGapWaitTries    = 10
+	verGapWaitInterval = 100 * time.Millisecond
+)
+
+// verStart之后的cmds已被压缩删除，客户端需要从文档当前版本重新加载
+var ErrSnapshotRequired = errors.New("snapshot required")
+
+// 检查从verStart开始已压缩的cmds是否都在归档中，返回已压缩到的版本
+// 以删除方式压缩后无法再获取，返回ErrSnapshotRequired
+func (s *CmdService) CheckCmdsAvailable(documentId string, verStart uint) (uint, error) {
+	archivedVer, err := s.GetArchivedVerId(documentId)
+	if err != nil {
+		return 0, err
+	}
+	// 版本从1开始分配
+	verStart = max(verStart, 1)
+	if verStart > archivedVer {
+		return archivedVer, nil
+	}
+	filter := bson.M{"document_id": documentId, "ver_id": bson.M{"$gte": verStart, "$lte": archivedVer}}
+	count, err := s.ArchiveCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return 0, err
+	}
+	if uint(count) < archivedVer-verStart+1 {
+		return archivedVer, ErrSnapshotRequired
+	}
+	return archivedVer, nil
+}
+
+// 版本号由计数器分配，缺少的版本可能仍在其它连接保存中
+type VerGapError struct {
+	VerIds []uint
+}
+
+func (e *VerGapError) Error() string {
+	return fmt.Sprintf("缺少版本 %v", e.VerIds)
+}
+
+// 获取[verStart, verEnd]范围内连续的cmds，有空缺时返回VerGapError，不等待，可在持有文档锁时调用
+// 已压缩的部分不会再保存，不算空缺，已被删除时返回ErrSnapshotRequired
+func (s *CmdService) GetCmdItemsNoGap(documentId string, verStart uint, verEnd uint) ([]CmdItem, error) {
+	archivedVer, err := s.CheckCmdsAvailable(documentId, verStart)
+	if err != nil {
+		return nil, err
+	}
+	cmdItems, err := s.GetCmdItems(documentId, verStart, verEnd)
+	if err != nil {
+		return nil, err
+	}
+	missing := sliceutil.FilterT(func(verId uint) bool {
+		return verId > archivedVer
+	}, MissingVerIds(cmdItems, verStart, verEnd)...)
+	if len(missing) > 0 {
+		return cmdItems, &VerGapError{VerIds: missing}
+	}
+	return cmdItems, nil
+}
+
+// 等待空缺的版本保存，超时后以空cmd占位，会阻塞约1秒，不应在持有文档锁时调用
+// 占位后，迟到的保存会因ver_id重复而失败并重新分配版本，之后不会再出现在此范围内
+func (s *CmdService) FillVerGap(documentId string, verIds []uint) error {
+	for i := 0; i < verGapWaitTries && len(verIds) > 0; i++ {
+		time.Sleep(verGapWaitInterval)
+		cmdItems, err := s.GetCmdItems(documentId, verIds[0], verIds[len(verIds)-1])
+		if err != nil {
+			return err
+		}
+		saved := make(map[uint]struct{}, len(cmdItems))
+		for _, item := range cmdItems {
+			saved[item.VerId] = struct{}{}
+		}
+		verIds = sliceutil.FilterT(func(verId uint) bool {
+			_, ok := saved[verId]
+			return !ok
+		}, verIds...)
+	}
+	if len(verIds) == 0 {
+		return nil
+	}
+	gapItems := sliceutil.MapT(func(verId uint) CmdItem {
+		return NewGapCmdItem(documentId, verId)
+	}, verIds...)
+	log.Println("填补空缺的版本", documentId, len(gapItems), gapItems[0].VerId)
+	// 部分版本可能刚被保存，忽略重复的
+	_, err := s.Collection.InsertMany(context.Background(), sliceutil.ConvertToAnySlice(gapItems), options.InsertMany().SetOrdered(false))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+// 删除指定版本的cmd，用于撤销未完成的操作写入的cmd
+func (s *CmdService) DeleteCmdItem(documentId string, verId uint) error {
+	_, err := s.Collection.DeleteOne(context.Background(), bson.M{"document_id": documentId, "ver_id": verId})
+	return err
+}
+
 // 获取特定id的cmd
 func (s *CmdService) GetCmd(document_id, cmd_id string) (*CmdItem, error) {
 	item := &CmdItem{}
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodb "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"kcaitech.com/kcserver/providers/mongo"
+	"kcaitech.com/kcserver/utils/sliceutil"
+)
+
+// 压缩方式
+const (
+	CmdCompactionArchive = "archive" // 移到归档集合，仍可查询
+	CmdCompactionDelete  = "delete"  // 直接删除
+)
+
+const cmdCompactionBatchSize = 1000
+
+// 每个文档已压缩到的版本，不大于此版本的cmd不在主集合中
+type cmdCompaction struct {
+	DocumentId  string `bson:"_id"`
+	ArchivedVer uint   `bson:"archived_ver"`
+}
+
+func newCmdArchiveCollection(mongoDB *mongo.MongoDB) *mongodb.Collection {
+	collection := mongoDB.DB.Collection("document_cmd_archive")
+	_, err := collection.Indexes().CreateOne(context.Background(), mongodb.IndexModel{
+		Keys:    bson.D{{Key: "document_id", Value: 1}, {Key: "ver_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		panic(err)
+	}
+	return collection
+}
+
+func findCmdItems(collection *mongodb.Collection, filter bson.M) ([]CmdItem, error) {
+	return findCmdItemsLimit(collection, filter, 0)
+}
+
+// 按ver_id顺序获取前limit条，limit为0时不限制
+func findCmdItemsLimit(collection *mongodb.Collection, filter bson.M, limit int64) ([]CmdItem, error) {
+	options := options.Find()
+	options.SetSort(bson.D{{Key: "ver_id", Value: 1}}).SetLimit(limit)
+	cursor, err := collection.Find(context.Background(), filter, options)
+	if err != nil {
+		return nil, err
+	}
+	cmdItems := make([]CmdItem, 0)
+	if err := cursor.All(context.Background(), &cmdItems); err != nil {
+		return nil, err
+	}
+	return cmdItems, nil
+}
+
+// 按ver_id顺序遍历，每次回调最多chunkSize条
+func iterCmdItems(ctx context.Context, collection *mongodb.Collection, filter bson.M, chunkSize int, fn func([]CmdItem) error) error {
+	findOptions := options.Find().SetSort(bson.D{{Key: "ver_id", Value: 1}}).SetBatchSize(int32(chunkSize))
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+	chunk := make([]CmdItem, 0, chunkSize)
+	for cursor.Next(ctx) {
+		item := CmdItem{}
+		if err := cursor.Decode(&item); err != nil {
+			return err
+		}
+		chunk = append(chunk, item)
+		if len(chunk) >= chunkSize {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			chunk = make([]CmdItem, 0, chunkSize)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if len(chunk) > 0 {
+		return fn(chunk)
+	}
+	return nil
+}
+
+// 文档已压缩到的版本，未压缩过返回0
+func (s *CmdService) GetArchivedVerId(documentId string) (uint, error) {
+	compaction := cmdCompaction{}
+	err := s.CompactionCollection.FindOne(context.Background(), bson.M{"_id": documentId}).Decode(&compaction)
+	if err == mongodb.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return compaction.ArchivedVer, nil
+}
+
+// 主集合中缺少起始部分时，从归档中补齐
+func (s *CmdService) prependArchived(documentId string, verStart uint, verEnd uint, cmdItems []CmdItem) ([]CmdItem, error) {
+	if len(cmdItems) > 0 && cmdItems[0].VerId == verStart {
+		return cmdItems, nil
+	}
+	archivedVer, err := s.GetArchivedVerId(documentId)
+	if err != nil || archivedVer < verStart {
+		return cmdItems, err
+	}
+	end := min(verEnd, archivedVer)
+	if len(cmdItems) > 0 {
+		end = min(end, cmdItems[0].VerId-1)
+	}
+	archived, err := findCmdItems(s.ArchiveCollection, bson.M{"document_id": documentId, "ver_id": bson.M{"$gte": verStart, "$lte": end}})
+	if err != nil {
+		return nil, err
+	}
+	return append(archived, cmdItems...), nil
+}
+
+// 压缩已被快照覆盖的cmd：ver_id不大于verEnd且早于before
+// 只压缩连续的前段，中途失败后重新执行即可
+func (s *CmdService) CompactCmds(documentId string, verEnd uint, before time.Time, mode string) (int64, error) {
+	// 不早于before的cmd及其之后的都保留
+	notBefore := CmdItem{}
+	findOptions := options.FindOne().SetSort(bson.D{{Key: "ver_id", Value: 1}})
+	filter := bson.M{"document_id": documentId, "ver_id": bson.M{"$lte": verEnd}, "_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(before)}}
+	if err := s.Collection.FindOne(context.Background(), filter, findOptions).Decode(&notBefore); err == nil {
+		if notBefore.VerId == 0 {
+			return 0, nil
+		}
+		verEnd = notBefore.VerId - 1
+	} else if err != mongodb.ErrNoDocuments {
+		return 0, err
+	}
+	archivedVer, err := s.GetArchivedVerId(documentId)
+	if err != nil || verEnd <= archivedVer {
+		return 0, err
+	}
+
+	compacted := int64(0)
+	for {
+		batchEnd := verEnd
+		if mode == CmdCompactionArchive {
+			// 已处理的批次已从主集合删除，每次取剩余的前一批
+			cmdItems, err := findCmdItemsLimit(s.Collection, bson.M{"document_id": documentId, "ver_id": bson.M{"$lte": verEnd}}, cmdCompactionBatchSize)
+			if err != nil {
+				return compacted, err
+			}
+			if len(cmdItems) == cmdCompactionBatchSize {
+				batchEnd = cmdItems[len(cmdItems)-1].VerId
+			}
+			if len(cmdItems) > 0 {
+				// 上次中断时可能已归档过一部分
+				_, err := s.ArchiveCollection.InsertMany(context.Background(), sliceutil.ConvertToAnySlice(cmdItems), options.InsertMany().SetOrdered(false))
+				if err != nil && !mongo.IsDuplicateKeyError(err) {
+					return compacted, err
+				}
+			}
+		}
+		// 先更新压缩位置，读取时转到归档，再从主集合删除
+		_, err = s.CompactionCollection.UpdateOne(context.Background(), bson.M{"_id": documentId}, bson.M{"$max": bson.M{"archived_ver": batchEnd}}, options.Update().SetUpsert(true))
+		if err != nil {
+			return compacted, err
+		}
+		res, err := s.Collection.DeleteMany(context.Background(), bson.M{"document_id": documentId, "ver_id": bson.M{"$lte": batchEnd}})
+		if err != nil {
+			return compacted, err
+		}
+		compacted += res.DeletedCount
+		if batchEnd >= verEnd {
+			return compacted, nil
+		}
+	}
+}
+
+// 删除文档的所有cmd及压缩、序列记录，用于彻底删除文档，可重复执行
+func (s *CmdService) DeleteDocumentCmds(documentId string) (int64, error) {
+	filter := bson.M{"document_id": documentId}
+	res, err := s.Collection.DeleteMany(context.Background(), filter)
+	if err != nil {
+		return 0, err
+	}
+	deleted := res.DeletedCount
+	res, err = s.ArchiveCollection.DeleteMany(context.Background(), filter)
+	if err != nil {
+		return deleted, err
+	}
+	deleted += res.DeletedCount
+	if _, err := s.CompactionCollection.DeleteOne(context.Background(), bson.M{"_id": documentId}); err != nil {
+		return deleted, err
+	}
+	if _, err := s.SeqCollection.DeleteOne(context.Background(), bson.M{"_id": documentId}); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
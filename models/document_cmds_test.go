@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMissingVerIds(t *testing.T) {
+	items := func(verIds ...uint) []CmdItem {
+		cmdItems := make([]CmdItem, 0, len(verIds))
+		for _, verId := range verIds {
+			cmdItems = append(cmdItems, CmdItem{VerId: verId})
+		}
+		return cmdItems
+	}
+	cases := []struct {
+		cmdItems []CmdItem
+		start    uint
+		end      uint
+		want     []uint
+	}{
+		{items(1, 2, 3), 1, 3, []uint{}},
+		{items(1, 3), 1, 3, []uint{2}},
+		{items(2), 1, 4, []uint{1, 3, 4}},
+		{items(), 5, 6, []uint{5, 6}},
+		{items(0, 5, 9), 4, 6, []uint{4, 6}},
+	}
+	for _, c := range cases {
+		if got := MissingVerIds(c.cmdItems, c.start, c.end); !slices.Equal(got, c.want) {
+			t.Errorf("MissingVerIds(%d, %d) = %v, want %v", c.start, c.end, got, c.want)
+		}
+	}
+	if gap := NewGapCmdItem("doc", 3); !gap.Cmd.IsGap() || gap.VerId != 3 || len(gap.Cmd.Ops) != 0 {
+		t.Errorf("unexpected gap cmd %+v", gap)
+	}
+}
+
+func TestRestoreVersionId(t *testing.T) {
+	cmd := NewRestoreCmd(1, "v2", "v1")
+	if got := cmd.RestoreVersionId(); got != "v2" {
+		t.Errorf("RestoreVersionId() = %s, want v2", got)
+	}
+	gap := NewGapCmdItem("doc", 1)
+	if got := gap.Cmd.RestoreVersionId(); got != "" {
+		t.Errorf("非恢复标记应返回空，实际%s", got)
+	}
+}
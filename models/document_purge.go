@@ -0,0 +1,51 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type DocumentPurgeStatus uint8
+
+const (
+	DocumentPurgeStatusPending DocumentPurgeStatus = iota // 待清理或清理失败待重试
+	DocumentPurgeStatusDone                               // 已清理
+)
+
+// DocumentPurge 彻底删除文档后的存储清理记录，文档记录删除后由此保留路径
+type DocumentPurge struct {
+	BaseModelStruct
+	DocumentId string              `gorm:"uniqueIndex;size:64" json:"document_id"`
+	Path       string              `gorm:"size:64" json:"path"`
+	Status     DocumentPurgeStatus `gorm:"default:0" json:"status"`
+	Attempts   int                 `gorm:"default:0" json:"attempts"`   // 已尝试次数
+	LastError  string              `gorm:"size:1024" json:"last_error"` // 最近一次失败原因
+	Objects    int64               `gorm:"default:0" json:"objects"`    // 删除的存储对象数
+	Cmds       int64               `gorm:"default:0" json:"cmds"`       // 删除的cmd数
+	Comments   int64               `gorm:"default:0" json:"comments"`   // 删除的评论数
+	PurgedAt   *time.Time          `gorm:"type:datetime(6)" json:"purged_at"`
+}
+
+func (model DocumentPurge) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(model)
+}
+
+func (model DocumentPurge) AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(model)
+}
+
+// tablename
+func (model DocumentPurge) TableName() string {
+	return "document_purge"
+}
@@ -18,6 +18,9 @@ type DocumentVersion struct {
 	DocumentId   string `gorm:"index" json:"document_id"`
 	VersionId    string `gorm:"index;size:64" json:"version_id"` // 这是个oss的版本id
 	LastCmdVerId uint   `gorm:"" json:"last_cmd_ver_id"`         // 此版本最后一个cmd的ver_id
+	Name         string `gorm:"size:128" json:"name"`            // 版本名称，为空时是自动保存的版本
+	Description  string `gorm:"size:1024" json:"description"`    // 版本描述
+	Pinned       bool   `gorm:"default:false" json:"pinned"`     // 固定的版本始终显示在命名版本列表中，cmd压缩（delete模式）时保留其之后的cmd
 }
 
 func (model DocumentVersion) MarshalJSON() ([]byte, error) {
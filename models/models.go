@@ -176,6 +176,11 @@ func (module *DBModule) AutoMigrate() error {
 	if err != nil {
 		return fmt.Errorf("DocumentVersion:%s", err.Error())
 	}
+	// document_purge
+	err = DocumentPurge{}.AutoMigrate(module.DB)
+	if err != nil {
+		return fmt.Errorf("DocumentPurge:%s", err.Error())
+	}
 	// document_lock
 	err = DocumentLock{}.AutoMigrate(module.DB)
 	if err != nil {
@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package broker
+
+import (
+	"context"
+)
+
+type Mode string
+
+const (
+	PubSub Mode = "pubsub" // 默认，订阅者断开期间的消息会丢失
+	Stream Mode = "stream" // redis streams，断线重连后从上次的位置继续读取
+)
+
+type Config struct {
+	Mode         Mode  `yaml:"mode" json:"mode"`
+	StreamMaxLen int64 `yaml:"stream_max_len" json:"stream_max_len"` // 每个stream保留的最大消息数
+	StreamTTL    int64 `yaml:"stream_ttl" json:"stream_ttl"`         // stream无新消息后的过期时间（秒）
+}
+
+type Message struct {
+	Id      string // stream消息id，pubsub为空
+	Channel string
+	Payload string
+}
+
+type Subscription interface {
+	Channel() <-chan *Message
+	Close()
+}
+
+type Broker interface {
+	Publish(ctx context.Context, channel string, payload any) error
+	// offset为空时从当前位置开始订阅，pubsub模式忽略offset
+	Subscribe(ctx context.Context, channel string, offset string) (Subscription, error)
+}
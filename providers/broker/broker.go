@@ -0,0 +1,28 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package broker
+
+import (
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func NewBroker(client *redis.Client, conf *Config) (Broker, error) {
+	switch conf.Mode {
+	case PubSub, "":
+		return NewPubSubBroker(client), nil
+	case Stream:
+		return NewStreamBroker(client, conf), nil
+	default:
+		return nil, errors.New("不支持的broker mode")
+	}
+}
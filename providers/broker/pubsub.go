@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type pubSubBroker struct {
+	client *redis.Client
+}
+
+func NewPubSubBroker(client *redis.Client) Broker {
+	return &pubSubBroker{client: client}
+}
+
+func (b *pubSubBroker) Publish(ctx context.Context, channel string, payload any) error {
+	return b.client.Publish(ctx, channel, payload).Err()
+}
+
+type pubSubSubscription struct {
+	pubsub    *redis.PubSub
+	channel   chan *Message
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+func (b *pubSubBroker) Subscribe(ctx context.Context, channel string, offset string) (Subscription, error) {
+	pubsub := b.client.Subscribe(ctx, channel)
+	// 确认订阅成功后再返回，避免丢失之后发布的消息
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		return nil, err
+	}
+	sub := &pubSubSubscription{
+		pubsub:  pubsub,
+		channel: make(chan *Message, subscriptionBufferSize),
+		quit:    make(chan struct{}),
+	}
+	go func() {
+		defer close(sub.channel)
+		ch := pubsub.Channel()
+		for {
+			select {
+			case v, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case sub.channel <- &Message{Channel: v.Channel, Payload: v.Payload}:
+				case <-sub.quit:
+					return
+				}
+			case <-sub.quit:
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+func (s *pubSubSubscription) Channel() <-chan *Message {
+	return s.channel
+}
+
+func (s *pubSubSubscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.quit)
+		_ = s.pubsub.Close()
+	})
+}
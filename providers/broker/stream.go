@@ -0,0 +1,290 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package broker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	subscriptionBufferSize = 256
+	streamBlockTimeout     = 500 * time.Millisecond
+	streamReadCount        = 100
+	streamPayloadField     = "payload"
+	defaultStreamMaxLen    = 1000
+	defaultStreamTTL       = 24 * 3600
+	maxStreamRetryBackoff  = 5 * time.Second
+)
+
+// 同一实例内所有订阅共用一个读取协程，避免每个订阅占用一个阻塞连接
+type streamBroker struct {
+	client *redis.Client
+	maxLen int64
+	ttl    time.Duration
+
+	mutex   sync.Mutex
+	streams map[string]*streamState
+	wake    chan struct{}
+	once    sync.Once
+}
+
+type streamState struct {
+	lastId string
+	subs   map[*streamSubscription]struct{}
+}
+
+type streamSubscription struct {
+	broker    *streamBroker
+	key       string
+	channel   chan *Message
+	closed    bool // 已被断开，由broker.mutex保护
+	closeOnce sync.Once
+}
+
+func NewStreamBroker(client *redis.Client, conf *Config) Broker {
+	maxLen := conf.StreamMaxLen
+	if maxLen <= 0 {
+		maxLen = defaultStreamMaxLen
+	}
+	ttl := conf.StreamTTL
+	if ttl <= 0 {
+		ttl = defaultStreamTTL
+	}
+	return &streamBroker{
+		client:  client,
+		maxLen:  maxLen,
+		ttl:     time.Duration(ttl) * time.Second,
+		streams: map[string]*streamState{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func (b *streamBroker) Publish(ctx context.Context, channel string, payload any) error {
+	pipe := b.client.Pipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: channel,
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: map[string]any{streamPayloadField: payload},
+	})
+	pipe.Expire(ctx, channel, b.ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (b *streamBroker) Subscribe(ctx context.Context, channel string, offset string) (Subscription, error) {
+	b.once.Do(func() {
+		go b.run()
+	})
+
+	sub := &streamSubscription{
+		broker: b,
+		key:    channel,
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state, ok := b.streams[channel]
+	if !ok {
+		lastId := offset
+		if lastId == "" {
+			var err error
+			if lastId, err = b.latestId(ctx, channel); err != nil {
+				return nil, err
+			}
+		}
+		state = &streamState{
+			lastId: lastId,
+			subs:   map[*streamSubscription]struct{}{},
+		}
+		b.streams[channel] = state
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	} else if offset != "" && compareStreamId(offset, state.lastId) < 0 {
+		// 读取协程已越过offset，补发(offset, lastId]之间的消息
+		msgs, err := b.client.XRange(ctx, channel, "("+offset, state.lastId).Result()
+		if err != nil {
+			return nil, err
+		}
+		// 补发的消息不占用缓冲区
+		sub.channel = make(chan *Message, len(msgs)+subscriptionBufferSize)
+		for _, msg := range msgs {
+			sub.channel <- toMessage(channel, msg)
+		}
+	}
+	if sub.channel == nil {
+		sub.channel = make(chan *Message, subscriptionBufferSize)
+	}
+	state.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+func (b *streamBroker) latestId(ctx context.Context, channel string) (string, error) {
+	msgs, err := b.client.XRevRangeN(ctx, channel, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+func (b *streamBroker) unsubscribe(sub *streamSubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	state, ok := b.streams[sub.key]
+	if !ok {
+		return
+	}
+	delete(state.subs, sub)
+	if !sub.closed {
+		sub.closed = true
+		close(sub.channel)
+	}
+	if len(state.subs) == 0 {
+		delete(b.streams, sub.key)
+	}
+}
+
+func (b *streamBroker) readArgs() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	keys := make([]string, 0, len(b.streams))
+	ids := make([]string, 0, len(b.streams))
+	for key, state := range b.streams {
+		keys = append(keys, key)
+		ids = append(ids, state.lastId)
+	}
+	return append(keys, ids...)
+}
+
+func (b *streamBroker) run() {
+	backoff := time.Duration(0)
+	for {
+		args := b.readArgs()
+		if len(args) == 0 {
+			<-b.wake
+			continue
+		}
+		res, err := b.client.XRead(context.Background(), &redis.XReadArgs{
+			Streams: args,
+			Count:   streamReadCount,
+			Block:   streamBlockTimeout,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			// 连接异常时等待重试，之后从lastId继续读取，期间的消息不会丢失
+			backoff = min(backoff*2+100*time.Millisecond, maxStreamRetryBackoff)
+			log.Println("stream broker xread err", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+		b.dispatch(res)
+	}
+}
+
+func (b *streamBroker) dispatch(streams []redis.XStream) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, stream := range streams {
+		state, ok := b.streams[stream.Stream]
+		if !ok {
+			continue
+		}
+		for _, msg := range stream.Messages {
+			// 读取期间订阅可能被重建，跳过已处理的消息
+			if compareStreamId(msg.ID, state.lastId) <= 0 {
+				continue
+			}
+			state.lastId = msg.ID
+			message := toMessage(stream.Stream, msg)
+			for sub := range state.subs {
+				sub.push(message)
+			}
+		}
+	}
+}
+
+func toMessage(channel string, msg redis.XMessage) *Message {
+	payload, _ := msg.Values[streamPayloadField].(string)
+	return &Message{
+		Id:      msg.ID,
+		Channel: channel,
+		Payload: payload,
+	}
+}
+
+// 比较stream id（ms-seq）
+func compareStreamId(a string, b string) int {
+	aMs, aSeq := parseStreamId(a)
+	bMs, bSeq := parseStreamId(b)
+	if aMs != bMs {
+		if aMs < bMs {
+			return -1
+		}
+		return 1
+	}
+	if aSeq != bSeq {
+		if aSeq < bSeq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func parseStreamId(id string) (uint64, uint64) {
+	msStr, seqStr, _ := strings.Cut(id, "-")
+	ms, _ := strconv.ParseUint(msStr, 10, 64)
+	seq, _ := strconv.ParseUint(seqStr, 10, 64)
+	return ms, seq
+}
+
+// 缓冲区满时断开订阅（关闭Channel），不阻塞其它订阅，订阅方可从最后收到的消息id重新订阅
+// 调用方需持有broker.mutex
+func (s *streamSubscription) push(message *Message) {
+	if s.closed {
+		return
+	}
+	select {
+	case s.channel <- message:
+	default:
+		log.Println("stream subscription buffer full, disconnect", s.key, message.Id)
+		s.closed = true
+		close(s.channel)
+		// 保留stream的读取位置，由Close删除
+	}
+}
+
+func (s *streamSubscription) Channel() <-chan *Message {
+	return s.channel
+}
+
+func (s *streamSubscription) Close() {
+	s.closeOnce.Do(func() {
+		s.broker.unsubscribe(s)
+	})
+}
@@ -0,0 +1,31 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package broker
+
+import "testing"
+
+func TestCompareStreamId(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1-0", "1-0", 0},
+		{"1-1", "1-0", 1},
+		{"1-9", "2-0", -1},
+		{"10-0", "9-99", 1},
+		{"0-0", "1700000000000-0", -1},
+	}
+	for _, c := range cases {
+		if got := compareStreamId(c.a, c.b); got != c.want {
+			t.Errorf("compareStreamId(%s, %s) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"context"
+	"time"
+
+	"kcaitech.com/kcserver/providers/broker"
+)
+
+type Provider string
+
+const (
+	Redis  Provider = "redis"  // 默认，多实例部署
+	Memory Provider = "memory" // 进程内，单机部署或测试，不依赖redis
+)
+
+type Config struct {
+	Provider Provider `yaml:"provider" json:"provider"`
+}
+
+type Message = broker.Message
+type Subscription = broker.Subscription
+
+type Publisher interface {
+	Publish(ctx context.Context, channel string, payload any) error
+}
+
+type Subscriber interface {
+	// offset为空时从当前位置开始订阅
+	Subscribe(ctx context.Context, channel string, offset string) (Subscription, error)
+}
+
+// 与redsync.Mutex的方法一致
+type Mutex interface {
+	Lock() error
+	TryLock() error
+	Unlock() (bool, error)
+}
+
+type Locker interface {
+	NewMutex(name string, expiry time.Duration) Mutex
+}
+
+// 认领的队列成员
+type QueueTask struct {
+	Member   string
+	Deadline int64 // 认领到期时间（unix毫秒），到期未Ack时重新可被取出
+}
+
+// 延迟队列，同一成员只保留一个，用于跨实例调度任务
+type Queue interface {
+	// 加入队列，成员已存在时保留较早的时间
+	Schedule(ctx context.Context, queue string, member string, at time.Time) error
+	// 取出已到期的成员并认领visibility时间，期间其它实例不会取到；实例崩溃未Ack的成员到期后重新取出
+	PopDue(ctx context.Context, queue string, count int, visibility time.Duration) ([]QueueTask, error)
+	// 处理完成，认领后没有被重新加入的成员从队列删除，同时清除失败次数
+	Ack(ctx context.Context, queue string, task QueueTask) error
+	// 记录一次失败，返回累计的失败次数，各实例共享
+	Fail(ctx context.Context, queue string, member string) (int, error)
+}
+
+type PubSub interface {
+	Publisher
+	Subscriber
+}
+
+type Bus interface {
+	Publisher
+	Subscriber
+	Locker
+	Queue
+	// 不保留的消息，只发给当前的订阅者，订阅时不补发，用于指针、表情等临时消息
+	// broker为stream模式时也不写入stream
+	Ephemeral() PubSub
+}
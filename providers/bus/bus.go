@@ -0,0 +1,32 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"errors"
+
+	"kcaitech.com/kcserver/providers/broker"
+	"kcaitech.com/kcserver/providers/redis"
+)
+
+func NewBus(conf *Config, redisDB *redis.RedisDB, brokerConf *broker.Config) (Bus, error) {
+	switch conf.Provider {
+	case Redis, "":
+		if redisDB == nil {
+			return nil, errors.New("redis未初始化")
+		}
+		return NewRedisBus(redisDB, brokerConf)
+	case Memory:
+		return NewMemoryBus(), nil
+	default:
+		return nil, errors.New("不支持的bus provider")
+	}
+}
@@ -0,0 +1,379 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	memoryHistorySize      = 1000
+	memoryBufferSize       = 256
+	memoryLockTries        = 32
+	memoryLockRetryDelay   = 100 * time.Millisecond
+	memoryDefaultLockValid = 8 * time.Second
+	memorySweepInterval    = time.Minute
+	memoryChannelIdleTTL   = 10 * time.Minute // 无订阅的channel保留历史消息的时间
+)
+
+var ErrLockFailed = errors.New("获取锁失败")
+var ErrLockNotHeld = errors.New("锁已失效")
+
+type memoryBus struct {
+	mutex     sync.Mutex
+	channels  map[string]*memoryChannel
+	locks     map[string]*memoryLock
+	queues    map[string]map[string]time.Time
+	fails     map[string]map[string]int
+	lastSweep time.Time
+}
+
+// 保留最近的消息，订阅时可以从offset之后补发
+type memoryChannel struct {
+	seq         uint64
+	history     []*Message
+	subs        map[*memorySubscription]struct{}
+	lastPublish time.Time
+}
+
+type memoryLock struct {
+	value    string
+	expireAt time.Time
+}
+
+type memorySubscription struct {
+	bus       *memoryBus
+	ch        *memoryChannel
+	channel   chan *Message
+	closed    bool // Channel已关闭，由bus.mutex保护
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewMemoryBus() Bus {
+	return &memoryBus{
+		channels: map[string]*memoryChannel{},
+		locks:    map[string]*memoryLock{},
+		queues:   map[string]map[string]time.Time{},
+		fails:    map[string]map[string]int{},
+	}
+}
+
+// 清理过期的锁及无订阅且长时间没有消息的channel，调用方需持有mutex
+func (b *memoryBus) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < memorySweepInterval {
+		return
+	}
+	b.lastSweep = now
+	for name, lock := range b.locks {
+		if now.After(lock.expireAt) {
+			delete(b.locks, name)
+		}
+	}
+	for name, ch := range b.channels {
+		if len(ch.subs) == 0 && now.Sub(ch.lastPublish) > memoryChannelIdleTTL {
+			delete(b.channels, name)
+		}
+	}
+}
+
+func (b *memoryBus) getChannel(name string) *memoryChannel {
+	ch, ok := b.channels[name]
+	if !ok {
+		ch = &memoryChannel{subs: map[*memorySubscription]struct{}{}}
+		b.channels[name] = ch
+	}
+	return ch
+}
+
+func toPayload(payload any) string {
+	switch v := payload.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (b *memoryBus) Publish(ctx context.Context, channel string, payload any) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.sweep(now)
+	ch := b.getChannel(channel)
+	ch.lastPublish = now
+	ch.seq++
+	message := &Message{
+		Id:      strconv.FormatUint(ch.seq, 10),
+		Channel: channel,
+		Payload: toPayload(payload),
+	}
+	ch.history = append(ch.history, message)
+	if len(ch.history) > memoryHistorySize {
+		ch.history = ch.history[len(ch.history)-memoryHistorySize:]
+	}
+	for sub := range ch.subs {
+		sub.push(message)
+	}
+	return nil
+}
+
+func (b *memoryBus) Subscribe(ctx context.Context, channel string, offset string) (Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var from uint64
+	if offset != "" {
+		var err error
+		if from, err = strconv.ParseUint(offset, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	b.mutex.Lock()
+	ch := b.getChannel(channel)
+	var replay []*Message
+	if offset != "" {
+		for _, message := range ch.history {
+			if seq, _ := strconv.ParseUint(message.Id, 10, 64); seq > from {
+				replay = append(replay, message)
+			}
+		}
+	}
+	// 补发的消息不占用缓冲区
+	sub := &memorySubscription{
+		bus:     b,
+		ch:      ch,
+		channel: make(chan *Message, len(replay)+memoryBufferSize),
+		quit:    make(chan struct{}),
+	}
+	for _, message := range replay {
+		sub.channel <- message
+	}
+	ch.subs[sub] = struct{}{}
+	b.mutex.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				sub.Close()
+			case <-sub.quit:
+			}
+		}()
+	}
+	return sub, nil
+}
+
+// 临时消息不记入历史，没有订阅者时直接丢弃
+type memoryEphemeral struct {
+	bus *memoryBus
+}
+
+func (b *memoryBus) Ephemeral() PubSub {
+	return &memoryEphemeral{bus: b}
+}
+
+func (e *memoryEphemeral) Publish(ctx context.Context, channel string, payload any) error {
+	b := e.bus
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.sweep(time.Now())
+	ch, ok := b.channels[channel]
+	if !ok {
+		return nil
+	}
+	message := &Message{
+		Channel: channel,
+		Payload: toPayload(payload),
+	}
+	for sub := range ch.subs {
+		sub.push(message)
+	}
+	return nil
+}
+
+func (e *memoryEphemeral) Subscribe(ctx context.Context, channel string, offset string) (Subscription, error) {
+	return e.bus.Subscribe(ctx, channel, "")
+}
+
+func (b *memoryBus) unsubscribe(sub *memorySubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(sub.ch.subs, sub)
+	if !sub.closed {
+		sub.closed = true
+		close(sub.channel)
+	}
+	// channel及历史消息保留到sweep清理，重新订阅时可以补发
+}
+
+// 缓冲区满时断开订阅，与stream broker一致，调用方需持有bus.mutex
+func (s *memorySubscription) push(message *Message) {
+	if s.closed {
+		return
+	}
+	select {
+	case s.channel <- message:
+	default:
+		s.closed = true
+		close(s.channel)
+	}
+}
+
+func (s *memorySubscription) Channel() <-chan *Message {
+	return s.channel
+}
+
+func (s *memorySubscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.quit)
+		s.bus.unsubscribe(s)
+	})
+}
+
+type memoryMutex struct {
+	bus    *memoryBus
+	name   string
+	expiry time.Duration
+	value  string
+}
+
+func (b *memoryBus) NewMutex(name string, expiry time.Duration) Mutex {
+	if expiry <= 0 {
+		expiry = memoryDefaultLockValid
+	}
+	return &memoryMutex{
+		bus:    b,
+		name:   name,
+		expiry: expiry,
+	}
+}
+
+func (m *memoryMutex) TryLock() error {
+	m.bus.mutex.Lock()
+	defer m.bus.mutex.Unlock()
+	now := time.Now()
+	m.bus.sweep(now)
+	if lock, ok := m.bus.locks[m.name]; ok && now.Before(lock.expireAt) {
+		return ErrLockFailed
+	}
+	m.value = uuid.NewString()
+	m.bus.locks[m.name] = &memoryLock{
+		value:    m.value,
+		expireAt: now.Add(m.expiry),
+	}
+	return nil
+}
+
+func (m *memoryMutex) Lock() error {
+	for i := 0; i < memoryLockTries; i++ {
+		if err := m.TryLock(); err == nil {
+			return nil
+		}
+		time.Sleep(memoryLockRetryDelay)
+	}
+	return ErrLockFailed
+}
+
+func (m *memoryMutex) Unlock() (bool, error) {
+	m.bus.mutex.Lock()
+	defer m.bus.mutex.Unlock()
+	lock, ok := m.bus.locks[m.name]
+	if !ok || lock.value != m.value || time.Now().After(lock.expireAt) {
+		return false, ErrLockNotHeld
+	}
+	delete(m.bus.locks, m.name)
+	return true, nil
+}
+
+func (b *memoryBus) Schedule(ctx context.Context, queue string, member string, at time.Time) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	q, ok := b.queues[queue]
+	if !ok {
+		q = map[string]time.Time{}
+		b.queues[queue] = q
+	}
+	if old, ok := q[member]; !ok || at.Before(old) {
+		q[member] = at
+	}
+	return nil
+}
+
+func (b *memoryBus) PopDue(ctx context.Context, queue string, count int, visibility time.Duration) ([]QueueTask, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	q := b.queues[queue]
+	now := time.Now()
+	due := make([]string, 0)
+	for member, at := range q {
+		if !at.After(now) {
+			due = append(due, member)
+		}
+	}
+	// 按到期时间先后取出
+	sort.Slice(due, func(i, j int) bool {
+		return q[due[i]].Before(q[due[j]])
+	})
+	if len(due) > count {
+		due = due[:count]
+	}
+	deadline := now.Add(visibility).Truncate(time.Millisecond)
+	tasks := make([]QueueTask, 0, len(due))
+	for _, member := range due {
+		q[member] = deadline
+		tasks = append(tasks, QueueTask{Member: member, Deadline: deadline.UnixMilli()})
+	}
+	return tasks, nil
+}
+
+func (b *memoryBus) Ack(ctx context.Context, queue string, task QueueTask) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if q, ok := b.queues[queue]; ok {
+		if at, ok := q[task.Member]; ok && at.UnixMilli() == task.Deadline {
+			delete(q, task.Member)
+		}
+		if len(q) == 0 {
+			delete(b.queues, queue)
+		}
+	}
+	if fails, ok := b.fails[queue]; ok {
+		delete(fails, task.Member)
+		if len(fails) == 0 {
+			delete(b.fails, queue)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBus) Fail(ctx context.Context, queue string, member string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	fails, ok := b.fails[queue]
+	if !ok {
+		fails = map[string]int{}
+		b.fails[queue] = fails
+	}
+	fails[member]++
+	return fails[member], nil
+}
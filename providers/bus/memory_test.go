@@ -0,0 +1,281 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub Subscription) *Message {
+	select {
+	case message := <-sub.Channel():
+		return message
+	case <-time.After(time.Second):
+		t.Fatal("没有收到消息")
+		return nil
+	}
+}
+
+func TestMemoryPublishSubscribe(t *testing.T) {
+	b := NewMemoryBus()
+	ctx := context.Background()
+	sub, err := b.Subscribe(ctx, "doc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	_ = b.Publish(ctx, "doc", []byte("a"))
+	_ = b.Publish(ctx, "other", "x")
+	_ = b.Publish(ctx, "doc", "b")
+
+	if m := receive(t, sub); m.Payload != "a" || m.Id != "1" {
+		t.Errorf("unexpected message %+v", m)
+	}
+	if m := receive(t, sub); m.Payload != "b" || m.Id != "2" {
+		t.Errorf("unexpected message %+v", m)
+	}
+}
+
+func TestMemorySubscribeFromOffset(t *testing.T) {
+	b := NewMemoryBus()
+	ctx := context.Background()
+	for _, payload := range []string{"a", "b", "c"} {
+		_ = b.Publish(ctx, "doc", payload)
+	}
+	sub, err := b.Subscribe(ctx, "doc", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if m := receive(t, sub); m.Payload != "b" {
+		t.Errorf("应从offset之后补发，实际%s", m.Payload)
+	}
+	if m := receive(t, sub); m.Payload != "c" {
+		t.Errorf("unexpected message %s", m.Payload)
+	}
+}
+
+func TestMemoryMutex(t *testing.T) {
+	b := NewMemoryBus()
+	m1 := b.NewMutex("lock", time.Second)
+	m2 := b.NewMutex("lock", time.Second)
+	if err := m1.TryLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m2.TryLock(); err == nil {
+		t.Error("锁已被占用，不应获取成功")
+	}
+	if ok, err := m2.Unlock(); ok || err == nil {
+		t.Error("未持有锁，不应释放成功")
+	}
+	if ok, err := m1.Unlock(); !ok || err != nil {
+		t.Error("释放锁失败", err)
+	}
+	if err := m2.TryLock(); err != nil {
+		t.Error("锁已释放，应获取成功", err)
+	}
+}
+
+func TestMemoryMutexExpiry(t *testing.T) {
+	b := NewMemoryBus()
+	m1 := b.NewMutex("lock", 10*time.Millisecond)
+	m2 := b.NewMutex("lock", time.Second)
+	if err := m1.TryLock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := m2.TryLock(); err != nil {
+		t.Error("锁已过期，应获取成功", err)
+	}
+	if ok, _ := m1.Unlock(); ok {
+		t.Error("过期的锁不应释放其他持有者的锁")
+	}
+}
+
+func TestMemoryQueue(t *testing.T) {
+	b := NewMemoryBus()
+	ctx := context.Background()
+	now := time.Now()
+	_ = b.Schedule(ctx, "q", "a", now.Add(-time.Second))
+	_ = b.Schedule(ctx, "q", "b", now.Add(time.Hour))
+	// 保留较早的时间
+	_ = b.Schedule(ctx, "q", "b", now.Add(-2*time.Second))
+	_ = b.Schedule(ctx, "q", "b", now.Add(time.Hour))
+	_ = b.Schedule(ctx, "q", "c", now.Add(time.Hour))
+
+	due, _ := b.PopDue(ctx, "q", 10, time.Minute)
+	if len(due) != 2 || due[0].Member != "b" || due[1].Member != "a" {
+		t.Fatalf("期望[b a]，实际%v", due)
+	}
+	if due, _ := b.PopDue(ctx, "q", 10, time.Minute); len(due) != 0 {
+		t.Errorf("已取出的不应再取到，实际%v", due)
+	}
+}
+
+func TestMemoryQueueAck(t *testing.T) {
+	b := NewMemoryBus()
+	ctx := context.Background()
+	_ = b.Schedule(ctx, "q", "a", time.Now())
+	_ = b.Schedule(ctx, "q", "b", time.Now())
+
+	// 认领超时未Ack的重新取出
+	due, _ := b.PopDue(ctx, "q", 10, 10*time.Millisecond)
+	if len(due) != 2 {
+		t.Fatalf("期望2个，实际%v", due)
+	}
+	time.Sleep(20 * time.Millisecond)
+	due, _ = b.PopDue(ctx, "q", 10, time.Minute)
+	if len(due) != 2 {
+		t.Fatalf("认领超时后应重新取出，实际%v", due)
+	}
+
+	// 认领后被重新加入的，Ack后仍保留
+	_ = b.Schedule(ctx, "q", "b", time.Now())
+	for _, task := range due {
+		_ = b.Ack(ctx, "q", task)
+	}
+	due, _ = b.PopDue(ctx, "q", 10, time.Minute)
+	if len(due) != 1 || due[0].Member != "b" {
+		t.Fatalf("期望[b]，实际%v", due)
+	}
+
+	// 失败次数累计，Ack后清除
+	if n, _ := b.Fail(ctx, "q", "b"); n != 1 {
+		t.Errorf("期望1，实际%d", n)
+	}
+	if n, _ := b.Fail(ctx, "q", "b"); n != 2 {
+		t.Errorf("期望2，实际%d", n)
+	}
+	_ = b.Ack(ctx, "q", due[0])
+	if n, _ := b.Fail(ctx, "q", "b"); n != 1 {
+		t.Errorf("Ack后应清除失败次数，实际%d", n)
+	}
+}
+
+func TestMemoryChannelCleanup(t *testing.T) {
+	b := NewMemoryBus().(*memoryBus)
+	ctx := context.Background()
+	sub, _ := b.Subscribe(ctx, "doc", "")
+	_ = b.Publish(ctx, "doc", "a")
+	sub.Close()
+
+	// 有订阅的channel不清理
+	active, _ := b.Subscribe(ctx, "active", "")
+	defer active.Close()
+
+	// 无订阅的channel及过期的锁由sweep清理
+	_ = b.NewMutex("lock", time.Millisecond).TryLock()
+	time.Sleep(2 * time.Millisecond)
+	b.mutex.Lock()
+	b.lastSweep = time.Time{}
+	b.sweep(time.Now().Add(memoryChannelIdleTTL + time.Second))
+	b.mutex.Unlock()
+	if _, ok := b.channels["active"]; len(b.channels) != 1 || !ok || len(b.locks) != 0 {
+		t.Errorf("应清理channel及锁，实际%d %d", len(b.channels), len(b.locks))
+	}
+}
+
+// 最后一个订阅关闭后保留历史，重新订阅时补发
+func TestMemoryResubscribeHistory(t *testing.T) {
+	b := NewMemoryBus()
+	ctx := context.Background()
+	sub, _ := b.Subscribe(ctx, "doc", "")
+	_ = b.Publish(ctx, "doc", "a")
+	m := receive(t, sub)
+	sub.Close()
+	_ = b.Publish(ctx, "doc", "b")
+
+	resub, err := b.Subscribe(ctx, "doc", m.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resub.Close()
+	if m := receive(t, resub); m.Payload != "b" {
+		t.Errorf("unexpected message %+v", m)
+	}
+}
+
+func TestMemorySlowSubscriber(t *testing.T) {
+	b := NewMemoryBus()
+	ctx := context.Background()
+	sub, _ := b.Subscribe(ctx, "doc", "")
+	defer sub.Close()
+	for i := 0; i <= memoryBufferSize; i++ {
+		_ = b.Publish(ctx, "doc", "a")
+	}
+	// 缓冲区满时断开，已缓冲的消息仍可读取
+	count := 0
+	for range sub.Channel() {
+		count++
+	}
+	if count != memoryBufferSize {
+		t.Errorf("期望%d，实际%d", memoryBufferSize, count)
+	}
+	// 可从最后收到的消息之后重新订阅
+	resub, err := b.Subscribe(ctx, "doc", strconv.Itoa(count))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resub.Close()
+	if m := receive(t, resub); m.Id != strconv.Itoa(count+1) {
+		t.Errorf("unexpected message %+v", m)
+	}
+}
+
+func TestMemorySubscribeContext(t *testing.T) {
+	b := NewMemoryBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, _ := b.Subscribe(ctx, "doc", "")
+	cancel()
+	select {
+	case _, ok := <-sub.Channel():
+		if ok {
+			t.Error("不应收到消息")
+		}
+	case <-time.After(time.Second):
+		t.Error("ctx取消后应关闭订阅")
+	}
+	if _, err := b.Subscribe(ctx, "doc", ""); err == nil {
+		t.Error("ctx已取消，不应订阅成功")
+	}
+}
+
+func TestMemoryEphemeral(t *testing.T) {
+	b := NewMemoryBus()
+	ctx := context.Background()
+	sub, err := b.Ephemeral().Subscribe(ctx, "doc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	_ = b.Ephemeral().Publish(ctx, "doc", "a")
+	if m := receive(t, sub); m.Payload != "a" || m.Id != "" {
+		t.Errorf("unexpected message %+v", m)
+	}
+
+	// 之后订阅的不会收到之前的临时消息
+	for _, subscriber := range []Subscriber{b, b.Ephemeral()} {
+		late, err := subscriber.Subscribe(ctx, "doc", "0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-late.Channel():
+			t.Errorf("不应收到临时消息 %+v", m)
+		case <-time.After(50 * time.Millisecond):
+		}
+		late.Close()
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redsync/redsync/v4"
+	goredis "github.com/redis/go-redis/v9"
+	"kcaitech.com/kcserver/providers/broker"
+	"kcaitech.com/kcserver/providers/redis"
+)
+
+type redisBus struct {
+	broker.Broker
+	ephemeral broker.Broker
+	client    *goredis.Client
+	redSync   *redsync.Redsync
+}
+
+func NewRedisBus(redisDB *redis.RedisDB, brokerConf *broker.Config) (Bus, error) {
+	b, err := broker.NewBroker(redisDB.Client, brokerConf)
+	if err != nil {
+		return nil, err
+	}
+	return &redisBus{
+		Broker:    b,
+		ephemeral: broker.NewPubSubBroker(redisDB.Client),
+		client:    redisDB.Client,
+		redSync:   redisDB.RedSync,
+	}, nil
+}
+
+func (b *redisBus) Ephemeral() PubSub {
+	return b.ephemeral
+}
+
+func (b *redisBus) NewMutex(name string, expiry time.Duration) Mutex {
+	return b.redSync.NewMutex(name, redsync.WithExpiry(expiry))
+}
+
+// 以sorted set实现，score为到期时间
+func (b *redisBus) Schedule(ctx context.Context, queue string, member string, at time.Time) error {
+	return b.client.ZAddLT(ctx, queue, goredis.Z{Score: float64(at.UnixMilli()), Member: member}).Err()
+}
+
+// 到期的成员的分数改为认领到期时间，到期前其它实例取不到
+var popDueScript = goredis.NewScript(`
+local members = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(members) do
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[3], member)
+end
+return members
+`)
+
+// 分数仍是认领时的值才删除，认领后被重新加入（分数变小）的需要再次处理
+var ackScript = goredis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+return 0
+`)
+
+const queueFailTTL = 24 * time.Hour
+
+func queueFailKey(queue string) string {
+	return queue + ":fail"
+}
+
+func (b *redisBus) PopDue(ctx context.Context, queue string, count int, visibility time.Duration) ([]QueueTask, error) {
+	now := time.Now()
+	deadline := now.Add(visibility).UnixMilli()
+	members, err := popDueScript.Run(ctx, b.client, []string{queue}, now.UnixMilli(), count, deadline).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+	tasks := make([]QueueTask, 0, len(members))
+	for _, member := range members {
+		tasks = append(tasks, QueueTask{Member: member, Deadline: deadline})
+	}
+	return tasks, nil
+}
+
+func (b *redisBus) Ack(ctx context.Context, queue string, task QueueTask) error {
+	return ackScript.Run(ctx, b.client, []string{queue, queueFailKey(queue)}, task.Member, strconv.FormatInt(task.Deadline, 10)).Err()
+}
+
+func (b *redisBus) Fail(ctx context.Context, queue string, member string) (int, error) {
+	pipe := b.client.TxPipeline()
+	incr := pipe.HIncrBy(ctx, queueFailKey(queue), member, 1)
+	pipe.Expire(ctx, queueFailKey(queue), queueFailTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"fmt"
+	"testing"
+
+	goredis "github.com/redis/go-redis/v9"
+	"kcaitech.com/kcserver/providers/broker"
+	"kcaitech.com/kcserver/providers/redis"
+)
+
+// stream模式下临时消息也走pubsub，不写入stream
+func TestRedisEphemeralPubSub(t *testing.T) {
+	client := goredis.NewClient(&goredis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	b, err := NewRedisBus(&redis.RedisDB{Client: client}, &broker.Config{Mode: broker.Stream})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%T", b.Ephemeral()); got != fmt.Sprintf("%T", broker.NewPubSubBroker(client)) {
+		t.Errorf("临时消息应使用pubsub，实际%s", got)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const maxResubscribeBackoff = 5 * time.Second
+
+type resumableSubscription struct {
+	channel   chan *Message
+	quit      chan struct{}
+	closeOnce sync.Once
+}
+
+// SubscribeResumable 订阅被断开（接收过慢）时，从最后收到的消息id重新订阅，期间的消息由broker补发
+// 接收方阻塞时对单个订阅形成背压，不影响其它订阅；pubsub模式的消息没有id，断开期间的消息仍会丢失
+func SubscribeResumable(ctx context.Context, subscriber Subscriber, channel string, offset string) (Subscription, error) {
+	sub, err := subscriber.Subscribe(ctx, channel, offset)
+	if err != nil {
+		return nil, err
+	}
+	r := &resumableSubscription{
+		channel: make(chan *Message),
+		quit:    make(chan struct{}),
+	}
+	go r.run(ctx, subscriber, channel, offset, sub)
+	return r, nil
+}
+
+func (r *resumableSubscription) run(ctx context.Context, subscriber Subscriber, channel string, lastId string, sub Subscription) {
+	defer close(r.channel)
+	defer func() {
+		// sub会被替换，需在退出时取当前值
+		sub.Close()
+	}()
+	for {
+		select {
+		case message, ok := <-sub.Channel():
+			if ok {
+				select {
+				case r.channel <- message:
+					if message.Id != "" {
+						lastId = message.Id
+					}
+				case <-r.quit:
+					return
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			// 先重新订阅再关闭旧的订阅，broker可保留读取位置
+			next := r.resubscribe(ctx, subscriber, channel, lastId)
+			if next == nil {
+				return
+			}
+			sub.Close()
+			sub = next
+		case <-r.quit:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *resumableSubscription) resubscribe(ctx context.Context, subscriber Subscriber, channel string, lastId string) Subscription {
+	backoff := time.Duration(0)
+	for {
+		sub, err := subscriber.Subscribe(ctx, channel, lastId)
+		if err == nil {
+			log.Println("resubscribe", channel, lastId)
+			return sub
+		}
+		backoff = min(backoff*2+100*time.Millisecond, maxResubscribeBackoff)
+		log.Println("resubscribe err", channel, lastId, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-r.quit:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (r *resumableSubscription) Channel() <-chan *Message {
+	return r.channel
+}
+
+func (r *resumableSubscription) Close() {
+	r.closeOnce.Do(func() {
+		close(r.quit)
+	})
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package bus
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+type fakeSubscription struct {
+	channel chan *Message
+}
+
+func (s *fakeSubscription) Channel() <-chan *Message {
+	return s.channel
+}
+
+func (s *fakeSubscription) Close() {}
+
+// 每次订阅返回预设的消息，之后关闭（模拟被断开）
+type fakeSubscriber struct {
+	mutex   sync.Mutex
+	offsets []string
+	batches [][]string
+}
+
+func (f *fakeSubscriber) Subscribe(ctx context.Context, channel string, offset string) (Subscription, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.offsets = append(f.offsets, offset)
+	ids := f.batches[0]
+	sub := &fakeSubscription{channel: make(chan *Message, len(ids))}
+	for _, id := range ids {
+		sub.channel <- &Message{Id: id, Channel: channel}
+	}
+	if len(f.batches) > 1 {
+		f.batches = f.batches[1:]
+		close(sub.channel)
+	}
+	return sub, nil
+}
+
+func TestSubscribeResumable(t *testing.T) {
+	subscriber := &fakeSubscriber{batches: [][]string{{"1", "2"}, {}, {"3"}}}
+	sub, err := SubscribeResumable(context.Background(), subscriber, "doc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	for _, want := range []string{"1", "2", "3"} {
+		if message := receive(t, sub); message.Id != want {
+			t.Fatalf("got %s, want %s", message.Id, want)
+		}
+	}
+	subscriber.mutex.Lock()
+	defer subscriber.mutex.Unlock()
+	// 断开后从最后收到的消息之后重新订阅
+	if want := []string{"", "2", "2"}; len(subscriber.offsets) != len(want) || subscriber.offsets[1] != "2" || subscriber.offsets[2] != "2" {
+		t.Fatalf("offsets %v, want %v", subscriber.offsets, want)
+	}
+}
+
+func TestSubscribeResumableClose(t *testing.T) {
+	subscriber := &fakeSubscriber{batches: [][]string{{}}}
+	sub, err := SubscribeResumable(context.Background(), subscriber, "doc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Close()
+	if _, ok := <-sub.Channel(); ok {
+		t.Fatal("关闭后Channel应被关闭")
+	}
+}
@@ -12,6 +12,7 @@ package mongo
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
@@ -24,6 +25,15 @@ import (
 // var DB *mongo.Database
 var IsDuplicateKeyError = mongo.IsDuplicateKeyError
 
+// 有序批量插入出错时，返回出错的位置，之前的数据已插入
+func GetWriteErrorIndex(err error) (int, bool) {
+	var bulkWriteException mongo.BulkWriteException
+	if errors.As(err, &bulkWriteException) && len(bulkWriteException.WriteErrors) > 0 {
+		return bulkWriteException.WriteErrors[0].Index, true
+	}
+	return 0, false
+}
+
 type MongoConf struct {
 	Url string `yaml:"url" json:"url"`
 	Db  string `yaml:"db" json:"db"`
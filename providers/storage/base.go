@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"time"
 )
 
 type Provider string
@@ -22,6 +23,7 @@ const (
 	MINIO Provider = "minio"
 	S3    Provider = "s3"
 	OSS   Provider = "oss"
+	LOCAL Provider = "local"
 )
 
 type Client interface {
@@ -43,6 +45,9 @@ type ClientConfig struct {
 	StsEndpoint string `yaml:"stsEndpoint" json:"stsEndpoint"`
 	AccountId   string `yaml:"accountId" json:"accountId"`
 	RoleName    string `yaml:"roleName" json:"roleName"`
+
+	// local，secretAccessKey用于签名访问令牌
+	RootPath string `yaml:"rootPath" json:"rootPath"`
 }
 
 type PutObjectInput struct {
@@ -62,11 +67,24 @@ type Bucket interface {
 	CopyDirectory(srcDirPath string, destDirPath string) (*UploadInfo, error)
 	GetObjectInfo(objectName string) (*ObjectInfo, error)
 	GetObject(objectName string) ([]byte, error)
+	GetObjectVersion(objectName string, versionId string) ([]byte, error) // 获取对象的历史版本
+	// 流式读取对象，由调用方关闭，返回的ObjectInfo与读取的内容来自同一次请求
+	GetObjectReader(objectName string, options *GetObjectOptions) (io.ReadCloser, *ObjectInfo, error)
 	DeleteObject(objectName string) error
 	ListObjects(prefix string) <-chan ObjectInfo
+	// 列举prefix下对象的所有版本，含删除标记，用于彻底删除
+	ListObjectVersions(prefix string) <-chan ObjectInfo
+	// 删除对象的指定版本（或删除标记），开启多版本时DeleteObject只会添加删除标记
+	DeleteObjectVersion(objectName string, versionId string) error
 	// PresignedGetObject(objectName string, expires time.Duration, reqParams url.Values) (string, error)
 }
 
+type GetObjectOptions struct {
+	VersionID string // 为空时为当前版本
+	Offset    int64  // 从offset读到结尾，不为0时ObjectInfo.Size可能为剩余长度
+	MatchETag string // 不为空时对象的ETag不一致则返回错误
+}
+
 type BucketConfig struct {
 	DocumentBucket string `yaml:"documentBucket" json:"documentBucket"`
 	AttatchBucket  string `yaml:"attatchBucket" json:"attatchBucket"`
@@ -83,10 +101,16 @@ type UploadInfo struct {
 }
 
 type ObjectInfo struct {
-	Key       string
-	Err       error
-	Size      int64
-	VersionID string
+	Key          string
+	Err          error
+	Size         int64
+	VersionID    string
+	ETag         string // 不含引号
+	LastModified time.Time
+
+	// ListObjectVersions返回
+	IsLatest       bool
+	IsDeleteMarker bool
 }
 
 type DefaultBucket struct {
@@ -130,7 +154,8 @@ const (
 	AuthOpPutObject  = 1 << 1
 	AuthOpDelObject  = 1 << 2
 	AuthOpListObject = 1 << 3
-	AuthOpAll        = AuthOpGetObject | AuthOpPutObject | AuthOpDelObject | AuthOpListObject
+	AuthOpGetVersion = 1 << 4 // 获取对象的历史版本
+	AuthOpAll        = AuthOpGetObject | AuthOpPutObject | AuthOpDelObject | AuthOpListObject | AuthOpGetVersion
 )
 
 func (that *DefaultBucket) GenerateAccessKey(authPath string, authOp int, expires int, roleSessionName string) (*AccessKeyValue, error) {
@@ -0,0 +1,579 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 本地目录存储，用于小规模自部署及CI
+// 目录结构：<RootPath>/<bucket>/objects/<key> 为当前版本
+//          <RootPath>/<bucket>/versions/<key>/<sha256> 为按内容寻址的各版本，VersionID即sha256
+// 客户端通过kcserver的/storage接口访问，GenerateAccessKey返回签名的令牌
+
+var (
+	ErrInvalidObjectName = errors.New("对象名称错误")
+	ErrInvalidToken      = errors.New("令牌无效")
+	ErrTokenExpired      = errors.New("令牌已过期")
+	ErrETagMismatch      = errors.New("对象已变化")
+)
+
+const (
+	LocalAccessKey = "local"
+	localTmpPrefix = ".local-tmp-" // 写入中的临时文件
+)
+
+type LocalClient struct {
+	config *ClientConfig
+}
+
+func NewLocalClient(config *ClientConfig) (Client, error) {
+	if config.RootPath == "" {
+		return nil, errors.New("local存储需要配置rootPath")
+	}
+	if config.SecretAccessKey == "" {
+		return nil, errors.New("local存储需要配置secretAccessKey用于签名")
+	}
+	if err := os.MkdirAll(config.RootPath, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalClient{
+		config: config,
+	}, nil
+}
+
+type LocalBucket struct {
+	DefaultBucket
+	config *BucketConfig
+	client *LocalClient
+}
+
+func (that *LocalClient) NewBucket(config *BucketConfig) Bucket {
+	instance := &LocalBucket{
+		config: config,
+		client: that,
+	}
+	instance.That = instance
+	return instance
+}
+
+func (that *LocalBucket) GetConfig() *Config {
+	return &Config{
+		Provider:     LOCAL,
+		ClientConfig: *that.client.config,
+		BucketConfig: *that.config,
+	}
+}
+
+func (that *LocalBucket) bucketPath(elem ...string) string {
+	return filepath.Join(append([]string{that.client.config.RootPath, that.config.DocumentBucket}, elem...)...)
+}
+
+// 对象名转为文件路径，不允许跳出bucket目录
+func cleanObjectName(objectName string) (string, error) {
+	name := strings.TrimLeft(path.Clean("/"+objectName), "/")
+	if name == "" || name == "." {
+		return "", ErrInvalidObjectName
+	}
+	return name, nil
+}
+
+func (that *LocalBucket) objectPath(objectName string) (string, error) {
+	name, err := cleanObjectName(objectName)
+	if err != nil {
+		return "", err
+	}
+	return that.bucketPath("objects", filepath.FromSlash(name)), nil
+}
+
+func (that *LocalBucket) versionDir(objectName string) (string, error) {
+	name, err := cleanObjectName(objectName)
+	if err != nil {
+		return "", err
+	}
+	return that.bucketPath("versions", filepath.FromSlash(name)), nil
+}
+
+func isVersionId(versionId string) bool {
+	if len(versionId) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(versionId)
+	return err == nil
+}
+
+// 先写入版本文件，再替换当前版本，写入过程中读到的都是完整文件
+func (that *LocalBucket) PutObject(putObjectInput *PutObjectInput) (*UploadInfo, error) {
+	objectPath, err := that.objectPath(putObjectInput.ObjectName)
+	if err != nil {
+		return nil, err
+	}
+	versionDir, _ := that.versionDir(putObjectInput.ObjectName)
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(versionDir, localTmpPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), putObjectInput.Reader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	versionId := hex.EncodeToString(hash.Sum(nil))
+	versionPath := filepath.Join(versionDir, versionId)
+	if _, err := os.Stat(versionPath); err != nil {
+		if err := os.Rename(tmp.Name(), versionPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0o755); err != nil {
+		return nil, err
+	}
+	tmpObject := filepath.Join(filepath.Dir(objectPath), localTmpPrefix+versionId+"-"+filepath.Base(objectPath))
+	_ = os.Remove(tmpObject)
+	if err := os.Link(versionPath, tmpObject); err != nil {
+		// 不支持硬链接时复制
+		if err := copyFile(versionPath, tmpObject); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Rename(tmpObject, objectPath); err != nil {
+		_ = os.Remove(tmpObject)
+		return nil, err
+	}
+	return &UploadInfo{
+		VersionID: versionId,
+	}, nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// 打开对象，versionId为空时为当前版本，由调用方关闭
+func (that *LocalBucket) OpenObject(objectName string, versionId string) (*os.File, error) {
+	filePath, err := that.objectPath(objectName)
+	if err != nil {
+		return nil, err
+	}
+	if versionId != "" {
+		if !isVersionId(versionId) {
+			return nil, fs.ErrNotExist
+		}
+		versionDir, _ := that.versionDir(objectName)
+		filePath = filepath.Join(versionDir, versionId)
+	}
+	return os.Open(filePath)
+}
+
+func (that *LocalBucket) GetObjectInfo(objectName string) (*ObjectInfo, error) {
+	file, err := that.OpenObject(objectName, "")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return localFileInfo(file, objectName)
+}
+
+// 读取整个文件计算版本，读取后文件位置在末尾
+func localFileInfo(file *os.File, objectName string) (*ObjectInfo, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	name, _ := cleanObjectName(objectName)
+	versionId := hex.EncodeToString(hash.Sum(nil))
+	return &ObjectInfo{
+		Key:          name,
+		Size:         stat.Size(),
+		VersionID:    versionId,
+		ETag:         versionId,
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
+func (that *LocalBucket) GetObject(objectName string) ([]byte, error) {
+	return that.GetObjectVersion(objectName, "")
+}
+
+func (that *LocalBucket) GetObjectVersion(objectName string, versionId string) ([]byte, error) {
+	file, err := that.OpenObject(objectName, versionId)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// 对象替换时是整体rename，已打开的文件内容不变
+func (that *LocalBucket) GetObjectReader(objectName string, options *GetObjectOptions) (io.ReadCloser, *ObjectInfo, error) {
+	file, err := that.OpenObject(objectName, options.VersionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := localFileInfo(file, objectName)
+	if err == nil && options.MatchETag != "" && options.MatchETag != info.ETag {
+		err = ErrETagMismatch
+	}
+	if err == nil {
+		_, err = file.Seek(options.Offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+func (that *LocalBucket) CopyObject(srcPath string, destPath string) (*UploadInfo, error) {
+	file, err := that.OpenObject(srcPath, "")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return that.PutObject(&PutObjectInput{
+		ObjectName: destPath,
+		Reader:     file,
+	})
+}
+
+func (that *LocalBucket) CopyDirectory(srcDirPath string, destDirPath string) (*UploadInfo, error) {
+	if srcDirPath == "" || srcDirPath == "/" || destDirPath == "" || destDirPath == "/" {
+		return nil, errors.New("路径不能为空")
+	}
+	// 复制失败时继续复制其它对象，返回第一个错误
+	var firstErr error
+	for objectInfo := range that.ListObjects(srcDirPath) {
+		if objectInfo.Err != nil {
+			log.Println("ListObjects异常：", objectInfo.Err)
+			if firstErr == nil {
+				firstErr = objectInfo.Err
+			}
+			continue
+		}
+		if _, err := that.CopyObject(objectInfo.Key, strings.Replace(objectInfo.Key, srcDirPath, destDirPath, 1)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return &UploadInfo{}, nil
+}
+
+// 删除当前版本及所有历史版本
+func (that *LocalBucket) DeleteObject(objectName string) error {
+	objectPath, err := that.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(objectPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	versionDir, _ := that.versionDir(objectName)
+	return os.RemoveAll(versionDir)
+}
+
+// 删除指定版本，删除的是当前版本时以剩余最新的版本为当前版本，没有剩余版本时删除对象
+func (that *LocalBucket) DeleteObjectVersion(objectName string, versionId string) error {
+	if versionId == "" {
+		return that.DeleteObject(objectName)
+	}
+	objectPath, err := that.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+	if !isVersionId(versionId) {
+		return nil
+	}
+	versionDir, _ := that.versionDir(objectName)
+	versionPath := filepath.Join(versionDir, versionId)
+	versionStat, err := os.Stat(versionPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	objectStat, err := os.Stat(objectPath)
+	isCurrent := err == nil && os.SameFile(objectStat, versionStat)
+	if err := os.Remove(versionPath); err != nil {
+		return err
+	}
+
+	var latest fs.FileInfo
+	entries, err := os.ReadDir(versionDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isVersionId(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if latest == nil || info.ModTime().After(latest.ModTime()) {
+			latest = info
+		}
+	}
+	if latest == nil {
+		return that.DeleteObject(objectName)
+	}
+	if !isCurrent {
+		return nil
+	}
+	latestPath := filepath.Join(versionDir, latest.Name())
+	tmpObject := filepath.Join(filepath.Dir(objectPath), localTmpPrefix+latest.Name()+"-"+filepath.Base(objectPath))
+	_ = os.Remove(tmpObject)
+	if err := os.Link(latestPath, tmpObject); err != nil {
+		if err := copyFile(latestPath, tmpObject); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmpObject, objectPath); err != nil {
+		_ = os.Remove(tmpObject)
+		return err
+	}
+	return nil
+}
+
+// 列举版本文件，当前版本以硬链接判断，不支持硬链接时IsLatest都为false
+// 没有版本文件的对象以空VersionID返回
+func (that *LocalBucket) ListObjectVersions(prefix string) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		prefix = strings.TrimLeft(prefix, "/")
+		versionsDir := that.bucketPath("versions")
+		walkDir := versionsDir
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			walkDir = filepath.Join(versionsDir, filepath.FromSlash(path.Clean("/"+prefix[:i])))
+		}
+		err := filepath.WalkDir(walkDir, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || !isVersionId(d.Name()) {
+				return nil
+			}
+			rel, err := filepath.Rel(versionsDir, filepath.Dir(filePath))
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			isLatest := false
+			if objectPath, err := that.objectPath(key); err == nil {
+				if objectStat, err := os.Stat(objectPath); err == nil {
+					isLatest = os.SameFile(objectStat, info)
+				}
+			}
+			ch <- ObjectInfo{
+				Key:          key,
+				Size:         info.Size(),
+				VersionID:    d.Name(),
+				ETag:         d.Name(),
+				LastModified: info.ModTime(),
+				IsLatest:     isLatest,
+			}
+			return nil
+		})
+		if err != nil {
+			ch <- ObjectInfo{Err: err}
+			return
+		}
+		for object := range that.ListObjects(prefix) {
+			if object.Err == nil {
+				versionDir, _ := that.versionDir(object.Key)
+				if _, err := os.Stat(versionDir); err == nil {
+					continue
+				}
+				object.IsLatest = true
+			}
+			ch <- object
+		}
+	}()
+	return ch
+}
+
+func (that *LocalBucket) ListObjects(prefix string) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		prefix = strings.TrimLeft(prefix, "/")
+		objectsDir := that.bucketPath("objects")
+		// 从前缀所在的目录开始遍历
+		walkDir := objectsDir
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			walkDir = filepath.Join(objectsDir, filepath.FromSlash(path.Clean("/"+prefix[:i])))
+		}
+		err := filepath.WalkDir(walkDir, func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || strings.HasPrefix(d.Name(), localTmpPrefix) {
+				return nil
+			}
+			rel, err := filepath.Rel(objectsDir, filePath)
+			if err != nil {
+				return err
+			}
+			key := filepath.ToSlash(rel)
+			if !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			ch <- ObjectInfo{
+				Key:          key,
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+			}
+			return nil
+		})
+		if err != nil {
+			ch <- ObjectInfo{Err: err}
+		}
+	}()
+	return ch
+}
+
+// 访问令牌的内容
+type LocalAccessClaims struct {
+	Bucket   string `json:"b"`
+	AuthPath string `json:"p"` // 以*结尾时为前缀匹配
+	AuthOp   int    `json:"o"`
+	Expires  int64  `json:"e"` // unix秒
+	Session  string `json:"s"`
+}
+
+// 对象名是否在授权范围内，且允许authOp操作
+func (claims *LocalAccessClaims) Allow(bucket string, objectName string, authOp int) bool {
+	if claims.Bucket != bucket || claims.AuthOp&authOp != authOp {
+		return false
+	}
+	name, err := cleanObjectName(objectName)
+	if err != nil {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(claims.AuthPath, "*"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	return name == claims.AuthPath
+}
+
+// 列举prefix下的对象是否在授权范围内
+func (claims *LocalAccessClaims) AllowList(bucket string, prefix string) bool {
+	if claims.Bucket != bucket || claims.AuthOp&AuthOpListObject == 0 {
+		return false
+	}
+	if authPrefix, ok := strings.CutSuffix(claims.AuthPath, "*"); ok {
+		return strings.HasPrefix(strings.TrimLeft(prefix, "/"), authPrefix)
+	}
+	return false
+}
+
+func signLocalToken(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (that *LocalBucket) GenerateAccessKey(authPath string, authOp int, expires int, roleSessionName string) (*AccessKeyValue, error) {
+	claims, err := json.Marshal(&LocalAccessClaims{
+		Bucket:   that.config.DocumentBucket,
+		AuthPath: strings.TrimLeft(authPath, "/"),
+		AuthOp:   authOp,
+		Expires:  time.Now().Add(time.Second * time.Duration(expires)).Unix(),
+		Session:  roleSessionName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return &AccessKeyValue{
+		AccessKey:    LocalAccessKey,
+		SessionToken: payload + "." + signLocalToken(that.client.config.SecretAccessKey, payload),
+	}, nil
+}
+
+// 校验GenerateAccessKey生成的令牌
+func (that *LocalBucket) VerifyAccessToken(token string) (*LocalAccessClaims, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signLocalToken(that.client.config.SecretAccessKey, payload))) {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := &LocalAccessClaims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() > claims.Expires {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
@@ -0,0 +1,301 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newTestLocalBucket(t *testing.T) *LocalBucket {
+	client, err := NewLocalClient(&ClientConfig{RootPath: t.TempDir(), SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.NewBucket(&BucketConfig{DocumentBucket: "document"}).(*LocalBucket)
+}
+
+func putString(t *testing.T, bucket *LocalBucket, objectName string, content string) string {
+	t.Helper()
+	info, err := bucket.PutObject(&PutObjectInput{ObjectName: objectName, Reader: strings.NewReader(content)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.VersionID
+}
+
+func TestCleanObjectName(t *testing.T) {
+	cases := []struct {
+		objectName string
+		want       string
+		wantErr    bool
+	}{
+		{"a/b.json", "a/b.json", false},
+		{"/a/b.json", "a/b.json", false},
+		{"a//b/./c", "a/b/c", false},
+		{"../../etc/passwd", "etc/passwd", false},
+		{"a/../../b", "b", false},
+		{"", "", true},
+		{"/", "", true},
+		{"..", "", true},
+	}
+	for _, c := range cases {
+		got, err := cleanObjectName(c.objectName)
+		if (err != nil) != c.wantErr || got != c.want {
+			t.Errorf("%q：期望%q %v，实际%q %v", c.objectName, c.want, c.wantErr, got, err)
+		}
+	}
+}
+
+func TestLocalAccessClaimsAllow(t *testing.T) {
+	prefixClaims := &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/*", AuthOp: AuthOpGetObject | AuthOpListObject}
+	exactClaims := &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/a.json", AuthOp: AuthOpAll}
+	cases := []struct {
+		name       string
+		claims     *LocalAccessClaims
+		bucket     string
+		objectName string
+		authOp     int
+		want       bool
+	}{
+		{"前缀内", prefixClaims, "document", "doc1/pages/p1", AuthOpGetObject, true},
+		{"前缀外", prefixClaims, "document", "doc2/pages/p1", AuthOpGetObject, false},
+		{"前缀相同的其它目录", prefixClaims, "document", "doc10/a", AuthOpGetObject, false},
+		{"跳出前缀", prefixClaims, "document", "doc1/../doc2/a", AuthOpGetObject, false},
+		{"操作未授权", prefixClaims, "document", "doc1/a", AuthOpPutObject, false},
+		{"bucket不同", prefixClaims, "attatch", "doc1/a", AuthOpGetObject, false},
+		{"完整路径", exactClaims, "document", "/doc1/a.json", AuthOpPutObject, true},
+		{"完整路径不同", exactClaims, "document", "doc1/a.json.bak", AuthOpGetObject, false},
+	}
+	for _, c := range cases {
+		if got := c.claims.Allow(c.bucket, c.objectName, c.authOp); got != c.want {
+			t.Errorf("%s：期望%v，实际%v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestLocalAccessClaimsAllowList(t *testing.T) {
+	cases := []struct {
+		name   string
+		claims *LocalAccessClaims
+		bucket string
+		prefix string
+		want   bool
+	}{
+		{"前缀内", &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/*", AuthOp: AuthOpListObject}, "document", "doc1/pages/", true},
+		{"开头的/", &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/*", AuthOp: AuthOpListObject}, "document", "/doc1/", true},
+		{"前缀外", &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/*", AuthOp: AuthOpListObject}, "document", "doc", false},
+		{"未授权列举", &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/*", AuthOp: AuthOpGetObject}, "document", "doc1/", false},
+		{"完整路径不能列举", &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/a", AuthOp: AuthOpListObject}, "document", "doc1/a", false},
+		{"bucket不同", &LocalAccessClaims{Bucket: "document", AuthPath: "doc1/*", AuthOp: AuthOpListObject}, "attatch", "doc1/", false},
+	}
+	for _, c := range cases {
+		if got := c.claims.AllowList(c.bucket, c.prefix); got != c.want {
+			t.Errorf("%s：期望%v，实际%v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestLocalVerifyAccessToken(t *testing.T) {
+	bucket := newTestLocalBucket(t)
+	valid, err := bucket.GenerateAccessKey("/doc1/*", AuthOpGetObject, 60, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := bucket.GenerateAccessKey("doc1/*", AuthOpGetObject, -60, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestLocalBucket(t)
+	other.client.config.SecretAccessKey = "other"
+	otherSecret, err := other.GenerateAccessKey("doc1/*", AuthOpGetObject, 60, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, signature, _ := strings.Cut(valid.SessionToken, ".")
+
+	cases := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"有效", valid.SessionToken, nil},
+		{"已过期", expired.SessionToken, ErrTokenExpired},
+		{"其它密钥签名", otherSecret.SessionToken, ErrInvalidToken},
+		{"篡改签名", payload + "." + signature + "x", ErrInvalidToken},
+		{"篡改内容", "x" + payload + "." + signature, ErrInvalidToken},
+		{"缺少签名", payload, ErrInvalidToken},
+	}
+	for _, c := range cases {
+		claims, err := bucket.VerifyAccessToken(c.token)
+		if !errors.Is(err, c.wantErr) {
+			t.Errorf("%s：期望%v，实际%v", c.name, c.wantErr, err)
+			continue
+		}
+		if err == nil && (claims.AuthPath != "doc1/*" || claims.Bucket != "document" || claims.Session != "s1") {
+			t.Errorf("%s：内容错误 %+v", c.name, claims)
+		}
+	}
+}
+
+func TestLocalPutObjectVersion(t *testing.T) {
+	bucket := newTestLocalBucket(t)
+	v1 := putString(t, bucket, "doc1/a.json", "v1")
+	v2 := putString(t, bucket, "doc1/a.json", "v2")
+	sum := sha256.Sum256([]byte("v1"))
+	if v1 != hex.EncodeToString(sum[:]) {
+		t.Errorf("VersionID应为内容的sha256：%s", v1)
+	}
+	// 相同内容的版本相同
+	if v := putString(t, bucket, "doc1/b.json", "v1"); v != v1 {
+		t.Errorf("相同内容的版本应相同：%s %s", v, v1)
+	}
+
+	cases := []struct {
+		name      string
+		versionId string
+		want      string
+		wantErr   bool
+	}{
+		{"当前版本", "", "v2", false},
+		{"历史版本", v1, "v1", false},
+		{"最新版本", v2, "v2", false},
+		{"不存在的版本", strings.Repeat("0", 64), "", true},
+		{"非法版本", "../../objects/doc1/a.json", "", true},
+	}
+	for _, c := range cases {
+		data, err := bucket.GetObjectVersion("doc1/a.json", c.versionId)
+		if c.wantErr {
+			if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s：期望不存在，实际%v", c.name, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(data, []byte(c.want)) {
+			t.Errorf("%s：期望%q，实际%q %v", c.name, c.want, data, err)
+		}
+	}
+
+	info, err := bucket.GetObjectInfo("/doc1/a.json")
+	if err != nil || info.VersionID != v2 || info.Key != "doc1/a.json" || info.Size != 2 {
+		t.Errorf("对象信息错误：%+v %v", info, err)
+	}
+	if err := bucket.DeleteObject("doc1/a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.GetObjectVersion("doc1/a.json", v1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("删除后历史版本应不存在：%v", err)
+	}
+}
+
+func TestLocalListObjects(t *testing.T) {
+	bucket := newTestLocalBucket(t)
+	for _, name := range []string{"doc1/a", "doc1/pages/p1", "doc10/a", "doc2/a"} {
+		putString(t, bucket, name, name)
+	}
+	cases := []struct {
+		prefix string
+		want   []string
+	}{
+		{"doc1/", []string{"doc1/a", "doc1/pages/p1"}},
+		{"/doc1/", []string{"doc1/a", "doc1/pages/p1"}},
+		{"doc1", []string{"doc1/a", "doc1/pages/p1", "doc10/a"}},
+		{"doc1/pages/p", []string{"doc1/pages/p1"}},
+		{"doc3/", nil},
+		{"", []string{"doc1/a", "doc1/pages/p1", "doc10/a", "doc2/a"}},
+	}
+	for _, c := range cases {
+		var got []string
+		for object := range bucket.ListObjects(c.prefix) {
+			if object.Err != nil {
+				t.Fatal(object.Err)
+			}
+			got = append(got, object.Key)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%q：期望%v，实际%v", c.prefix, c.want, got)
+		}
+	}
+}
+
+func TestLocalCopyDirectory(t *testing.T) {
+	bucket := newTestLocalBucket(t)
+	putString(t, bucket, "doc1/a", "a")
+	putString(t, bucket, "doc1/pages/p1", "p1")
+	if _, err := bucket.CopyDirectory("doc1/", "doc2/"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := bucket.GetObject("doc2/pages/p1"); err != nil || string(data) != "p1" {
+		t.Errorf("复制结果错误：%q %v", data, err)
+	}
+	// 目标目录已是对象，复制失败时返回错误
+	putString(t, bucket, "doc3", "file")
+	if _, err := bucket.CopyDirectory("doc1/", "doc3/"); err == nil {
+		t.Error("复制失败时应返回错误")
+	}
+}
+
+func listVersions(t *testing.T, bucket *LocalBucket, prefix string) []ObjectInfo {
+	t.Helper()
+	var versions []ObjectInfo
+	for object := range bucket.ListObjectVersions(prefix) {
+		if object.Err != nil {
+			t.Fatal(object.Err)
+		}
+		versions = append(versions, object)
+	}
+	return versions
+}
+
+func TestLocalObjectVersions(t *testing.T) {
+	bucket := newTestLocalBucket(t)
+	v1 := putString(t, bucket, "doc1/a", "v1")
+	v2 := putString(t, bucket, "doc1/a", "v2")
+	putString(t, bucket, "doc2/a", "other")
+
+	versions := listVersions(t, bucket, "doc1/")
+	if len(versions) != 2 {
+		t.Fatalf("期望2个版本，实际%v", versions)
+	}
+	for _, version := range versions {
+		if version.Key != "doc1/a" || version.IsLatest != (version.VersionID == v2) {
+			t.Errorf("版本信息错误：%+v", version)
+		}
+	}
+
+	// 删除当前版本后上一个版本成为当前版本
+	if err := bucket.DeleteObjectVersion("doc1/a", v2); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := bucket.GetObject("doc1/a"); err != nil || string(data) != "v1" {
+		t.Errorf("期望当前版本为v1，实际%q %v", data, err)
+	}
+	// 删除最后一个版本后对象不存在
+	if err := bucket.DeleteObjectVersion("doc1/a", v1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bucket.GetObject("doc1/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("删除所有版本后对象应不存在：%v", err)
+	}
+	if versions := listVersions(t, bucket, "doc1/"); len(versions) != 0 {
+		t.Errorf("删除所有版本后不应有剩余：%v", versions)
+	}
+	if versions := listVersions(t, bucket, "doc2/"); len(versions) != 1 {
+		t.Errorf("其它目录的版本不应受影响：%v", versions)
+	}
+}
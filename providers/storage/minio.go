@@ -15,6 +15,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"log"
 	"strconv"
 	"strings"
@@ -105,16 +106,24 @@ func (that *MinioBucket) GetObjectInfo(objectName string) (*ObjectInfo, error) {
 		return nil, err
 	}
 	return &ObjectInfo{
-		VersionID: objectInfo.VersionID,
+		Key:          objectInfo.Key,
+		Size:         objectInfo.Size,
+		VersionID:    objectInfo.VersionID,
+		ETag:         objectInfo.ETag,
+		LastModified: objectInfo.LastModified,
 	}, nil
 }
 
 func (that *MinioBucket) GetObject(objectName string) ([]byte, error) {
+	return that.GetObjectVersion(objectName, "")
+}
+
+func (that *MinioBucket) GetObjectVersion(objectName string, versionId string) ([]byte, error) {
 	object, err := that.client.client.GetObject(
 		context.Background(),
 		that.config.DocumentBucket,
 		objectName,
-		minio.GetObjectOptions{},
+		minio.GetObjectOptions{VersionID: versionId},
 	)
 	if err != nil {
 		return nil, err
@@ -128,11 +137,43 @@ func (that *MinioBucket) GetObject(objectName string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (that *MinioBucket) GetObjectReader(objectName string, options *GetObjectOptions) (io.ReadCloser, *ObjectInfo, error) {
+	opts := minio.GetObjectOptions{VersionID: options.VersionID}
+	if options.Offset > 0 {
+		if err := opts.SetRange(options.Offset, 0); err != nil {
+			return nil, nil, err
+		}
+	}
+	if options.MatchETag != "" {
+		if err := opts.SetMatchETag(options.MatchETag); err != nil {
+			return nil, nil, err
+		}
+	}
+	object, err := that.client.client.GetObject(context.Background(), that.config.DocumentBucket, objectName, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Stat时才发出请求
+	objectInfo, err := object.Stat()
+	if err != nil {
+		object.Close()
+		return nil, nil, err
+	}
+	return object, &ObjectInfo{
+		Key:          objectInfo.Key,
+		Size:         objectInfo.Size,
+		VersionID:    objectInfo.VersionID,
+		ETag:         objectInfo.ETag,
+		LastModified: objectInfo.LastModified,
+	}, nil
+}
+
 var minioAuthOpMap = map[int]string{
 	AuthOpGetObject:  "s3:GetObject",
 	AuthOpPutObject:  "s3:PutObject",
 	AuthOpDelObject:  "s3:DeleteObject",
 	AuthOpListObject: "s3:ListBucket",
+	AuthOpGetVersion: "s3:GetObjectVersion",
 }
 
 func (that *MinioBucket) GenerateAccessKey(authPath string, authOp int, expires int, roleSessionName string) (*AccessKeyValue, error) {
@@ -229,6 +270,33 @@ func (that *MinioBucket) DeleteObject(objectName string) error {
 	return that.client.client.RemoveObject(context.Background(), that.config.DocumentBucket, objectName, minio.RemoveObjectOptions{})
 }
 
+func (that *MinioBucket) DeleteObjectVersion(objectName string, versionId string) error {
+	return that.client.client.RemoveObject(context.Background(), that.config.DocumentBucket, objectName, minio.RemoveObjectOptions{VersionID: versionId})
+}
+
+func (that *MinioBucket) ListObjectVersions(prefix string) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		for objectInfo := range that.client.client.ListObjects(context.Background(), that.config.DocumentBucket, minio.ListObjectsOptions{
+			Prefix:       prefix,
+			Recursive:    true,
+			WithVersions: true,
+		}) {
+			ch <- ObjectInfo{
+				Key:            objectInfo.Key,
+				Err:            objectInfo.Err,
+				Size:           objectInfo.Size,
+				VersionID:      objectInfo.VersionID,
+				LastModified:   objectInfo.LastModified,
+				IsLatest:       objectInfo.IsLatest,
+				IsDeleteMarker: objectInfo.IsDeleteMarker,
+			}
+		}
+	}()
+	return ch
+}
+
 func (that *MinioBucket) ListObjects(prefix string) <-chan ObjectInfo {
 	ch := make(chan ObjectInfo)
 	go func() {
@@ -238,10 +306,11 @@ func (that *MinioBucket) ListObjects(prefix string) <-chan ObjectInfo {
 			Recursive: true,
 		}) {
 			ch <- ObjectInfo{
-				Key:       objectInfo.Key,
-				Err:       objectInfo.Err,
-				Size:      objectInfo.Size,
-				VersionID: objectInfo.VersionID,
+				Key:          objectInfo.Key,
+				Err:          objectInfo.Err,
+				Size:         objectInfo.Size,
+				VersionID:    objectInfo.VersionID,
+				LastModified: objectInfo.LastModified,
 			}
 		}
 	}()
@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -95,13 +96,27 @@ func (that *OSSBucket) GetObjectInfo(objectName string) (*ObjectInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	size, _ := strconv.ParseInt(meta.Get("Content-Length"), 10, 64)
+	lastModified, _ := http.ParseTime(meta.Get("Last-Modified"))
 	return &ObjectInfo{
-		VersionID: meta.Get("x-oss-version-id"),
+		Key:          objectName,
+		Size:         size,
+		VersionID:    meta.Get("x-oss-version-id"),
+		ETag:         strings.Trim(meta.Get("ETag"), "\""),
+		LastModified: lastModified,
 	}, nil
 }
 
 func (that *OSSBucket) GetObject(objectName string) ([]byte, error) {
-	readCloser, err := that.bucket.GetObject(objectName)
+	return that.GetObjectVersion(objectName, "")
+}
+
+func (that *OSSBucket) GetObjectVersion(objectName string, versionId string) ([]byte, error) {
+	options := []oss.Option{}
+	if versionId != "" {
+		options = append(options, oss.VersionId(versionId))
+	}
+	readCloser, err := that.bucket.GetObject(objectName, options...)
 	if err != nil {
 		return nil, err
 	}
@@ -114,11 +129,39 @@ func (that *OSSBucket) GetObject(objectName string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (that *OSSBucket) GetObjectReader(objectName string, options *GetObjectOptions) (io.ReadCloser, *ObjectInfo, error) {
+	var respHeader http.Header
+	ossOptions := []oss.Option{oss.GetResponseHeader(&respHeader)}
+	if options.VersionID != "" {
+		ossOptions = append(ossOptions, oss.VersionId(options.VersionID))
+	}
+	if options.Offset > 0 {
+		ossOptions = append(ossOptions, oss.NormalizedRange(strconv.FormatInt(options.Offset, 10)+"-"))
+	}
+	if options.MatchETag != "" {
+		ossOptions = append(ossOptions, oss.IfMatch(strconv.Quote(options.MatchETag)))
+	}
+	readCloser, err := that.bucket.GetObject(objectName, ossOptions...)
+	if err != nil {
+		return nil, nil, err
+	}
+	size, _ := strconv.ParseInt(respHeader.Get("Content-Length"), 10, 64)
+	lastModified, _ := http.ParseTime(respHeader.Get("Last-Modified"))
+	return readCloser, &ObjectInfo{
+		Key:          objectName,
+		Size:         size,
+		VersionID:    respHeader.Get("x-oss-version-id"),
+		ETag:         strings.Trim(respHeader.Get("ETag"), "\""),
+		LastModified: lastModified,
+	}, nil
+}
+
 var ossAuthOpMap = map[int][]string{
 	AuthOpGetObject:  {"oss:GetObject", "oss:GetObjectAcl", "oss:GetObjectVersion", "oss:GetObjectVersionAcl"},
 	AuthOpPutObject:  {"oss:PutObject", "oss:PutObjectAcl", "oss:PutObjectVersionAcl"},
 	AuthOpDelObject:  {"oss:DeleteObject", "oss:DeleteObjectVersion"},
 	AuthOpListObject: {"oss:ListObjects", "oss:ListObjectVersions"},
+	AuthOpGetVersion: {"oss:GetObjectVersion", "oss:GetObjectVersionAcl"},
 }
 
 func (that *OSSBucket) GenerateAccessKey(authPath string, authOp int, expires int, roleSessionName string) (*AccessKeyValue, error) {
@@ -204,21 +247,85 @@ func (that *OSSBucket) DeleteObject(objectName string) error {
 	return that.bucket.DeleteObject(objectName)
 }
 
-func (that *OSSBucket) ListObjects(prefix string) <-chan ObjectInfo {
+func (that *OSSBucket) DeleteObjectVersion(objectName string, versionId string) error {
+	if versionId == "" {
+		return that.bucket.DeleteObject(objectName)
+	}
+	return that.bucket.DeleteObject(objectName, oss.VersionId(versionId))
+}
+
+func (that *OSSBucket) ListObjectVersions(prefix string) <-chan ObjectInfo {
 	ch := make(chan ObjectInfo)
 	go func() {
 		defer close(ch)
-		result, err := that.bucket.ListObjectsV2(oss.Prefix(prefix))
-		if err != nil {
-			ch <- ObjectInfo{Err: err}
-			return
+		// 每次最多返回1000个，需按KeyMarker、VersionIdMarker翻页
+		options := []oss.Option{oss.Prefix(prefix), oss.MaxKeys(1000)}
+		for {
+			result, err := that.bucket.ListObjectVersions(options...)
+			if err != nil {
+				ch <- ObjectInfo{Err: err}
+				return
+			}
+			for _, version := range result.ObjectVersions {
+				ch <- ObjectInfo{
+					Key:          version.Key,
+					Size:         version.Size,
+					VersionID:    version.VersionId,
+					ETag:         strings.Trim(version.ETag, "\""),
+					LastModified: version.LastModified,
+					IsLatest:     version.IsLatest,
+				}
+			}
+			for _, marker := range result.ObjectDeleteMarkers {
+				ch <- ObjectInfo{
+					Key:            marker.Key,
+					VersionID:      marker.VersionId,
+					LastModified:   marker.LastModified,
+					IsLatest:       marker.IsLatest,
+					IsDeleteMarker: true,
+				}
+			}
+			if !result.IsTruncated {
+				return
+			}
+			if result.NextKeyMarker == "" && result.NextVersionIdMarker == "" {
+				ch <- ObjectInfo{Err: errors.New("oss列举版本缺少KeyMarker")}
+				return
+			}
+			options = []oss.Option{oss.Prefix(prefix), oss.MaxKeys(1000), oss.KeyMarker(result.NextKeyMarker), oss.VersionIdMarker(result.NextVersionIdMarker)}
 		}
-		for _, objectInfo := range result.Objects {
-			ch <- ObjectInfo{
-				Key:       objectInfo.Key,
-				Size:      objectInfo.Size,
-				VersionID: "",
+	}()
+	return ch
+}
+
+func (that *OSSBucket) ListObjects(prefix string) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		// 每次最多返回1000个，需按ContinuationToken翻页
+		options := []oss.Option{oss.Prefix(prefix), oss.MaxKeys(1000)}
+		for {
+			result, err := that.bucket.ListObjectsV2(options...)
+			if err != nil {
+				ch <- ObjectInfo{Err: err}
+				return
+			}
+			for _, objectInfo := range result.Objects {
+				ch <- ObjectInfo{
+					Key:          objectInfo.Key,
+					Size:         objectInfo.Size,
+					VersionID:    "",
+					LastModified: objectInfo.LastModified,
+				}
+			}
+			if !result.IsTruncated {
+				return
+			}
+			if result.NextContinuationToken == "" {
+				ch <- ObjectInfo{Err: errors.New("oss列举对象缺少ContinuationToken")}
+				return
 			}
+			options = []oss.Option{oss.Prefix(prefix), oss.MaxKeys(1000), oss.ContinuationToken(result.NextContinuationToken)}
 		}
 	}()
 	return ch
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// 可定位的对象读取，用于http.ServeContent，不把整个对象读入内存
+// 定位后按需从存储重新读取，重新读取时要求ETag与第一次读取的一致
+type ObjectReader struct {
+	bucket     Bucket
+	objectName string
+	versionId  string
+	info       ObjectInfo
+	body       io.ReadCloser
+	bodyOffset int64 // body当前读到的位置
+	offset     int64
+}
+
+func NewObjectReader(bucket Bucket, objectName string, versionId string) (*ObjectReader, error) {
+	body, info, err := bucket.GetObjectReader(objectName, &GetObjectOptions{VersionID: versionId})
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectReader{
+		bucket:     bucket,
+		objectName: objectName,
+		versionId:  versionId,
+		info:       *info,
+		body:       body,
+	}, nil
+}
+
+// 第一次读取时的对象信息
+func (that *ObjectReader) Info() *ObjectInfo {
+	return &that.info
+}
+
+func (that *ObjectReader) Read(p []byte) (int, error) {
+	if that.offset >= that.info.Size {
+		return 0, io.EOF
+	}
+	if that.body != nil && that.bodyOffset != that.offset {
+		if seeker, ok := that.body.(io.Seeker); ok {
+			if _, err := seeker.Seek(that.offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+			that.bodyOffset = that.offset
+		} else {
+			that.body.Close()
+			that.body = nil
+		}
+	}
+	if that.body == nil {
+		body, _, err := that.bucket.GetObjectReader(that.objectName, &GetObjectOptions{
+			VersionID: that.versionId,
+			Offset:    that.offset,
+			MatchETag: that.info.ETag,
+		})
+		if err != nil {
+			return 0, err
+		}
+		that.body = body
+		that.bodyOffset = that.offset
+	}
+	n, err := that.body.Read(p)
+	that.offset += int64(n)
+	that.bodyOffset = that.offset
+	return n, err
+}
+
+func (that *ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += that.offset
+	case io.SeekEnd:
+		offset += that.info.Size
+	default:
+		return 0, errors.New("whence错误")
+	}
+	if offset < 0 {
+		return 0, errors.New("offset错误")
+	}
+	that.offset = offset
+	return offset, nil
+}
+
+func (that *ObjectReader) Close() error {
+	if that.body == nil {
+		return nil
+	}
+	err := that.body.Close()
+	that.body = nil
+	return err
+}
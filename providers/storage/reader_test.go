@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package storage
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 返回不可定位的body，模拟远程存储
+type streamBucket struct {
+	*LocalBucket
+	opens int
+}
+
+type streamBody struct {
+	io.ReadCloser
+}
+
+func (that *streamBucket) GetObjectReader(objectName string, options *GetObjectOptions) (io.ReadCloser, *ObjectInfo, error) {
+	that.opens++
+	body, info, err := that.LocalBucket.GetObjectReader(objectName, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return streamBody{body}, info, nil
+}
+
+func TestObjectReaderRange(t *testing.T) {
+	local := newTestLocalBucket(t)
+	putString(t, local, "doc1/a", "0123456789")
+	bucket := &streamBucket{LocalBucket: local}
+	reader, err := NewObjectReader(bucket, "doc1/a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	cases := []struct {
+		rangeHeader string
+		wantStatus  int
+		wantBody    string
+	}{
+		{"", http.StatusOK, "0123456789"},
+		{"bytes=3-5", http.StatusPartialContent, "345"},
+		{"bytes=-2", http.StatusPartialContent, "89"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/doc1/a", nil)
+		if c.rangeHeader != "" {
+			req.Header.Set("Range", c.rangeHeader)
+		}
+		if _, err := reader.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		// 与代理接口一致，设置Content-Type后ServeContent不再读取内容判断类型
+		rec.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(rec, req, "a", time.Time{}, reader)
+		if rec.Code != c.wantStatus || rec.Body.String() != c.wantBody {
+			t.Errorf("%q：期望%d %q，实际%d %q", c.rangeHeader, c.wantStatus, c.wantBody, rec.Code, rec.Body.String())
+		}
+	}
+	// 第一次请求从头读取不需要重新打开，之后每个range打开一次
+	if bucket.opens != 3 {
+		t.Errorf("期望打开3次，实际%d", bucket.opens)
+	}
+}
+
+func TestObjectReaderChanged(t *testing.T) {
+	local := newTestLocalBucket(t)
+	putString(t, local, "doc1/a", "0123456789")
+	reader, err := NewObjectReader(&streamBucket{LocalBucket: local}, "doc1/a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	// 读取期间对象被替换，重新读取时不能返回新内容
+	putString(t, local, "doc1/a", "abcdefghij")
+	if _, err := reader.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(reader); err == nil {
+		t.Errorf("对象变化后应返回错误，实际读到%q", data)
+	}
+}
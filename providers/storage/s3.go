@@ -14,6 +14,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"io"
 	"strconv"
 	"strings"
 
@@ -110,15 +111,27 @@ func (that *S3Bucket) GetObjectInfo(objectName string) (*ObjectInfo, error) {
 		return nil, err
 	}
 	return &ObjectInfo{
-		VersionID: *result.VersionId,
+		Key:          objectName,
+		Size:         aws.Int64Value(result.ContentLength),
+		VersionID:    aws.StringValue(result.VersionId),
+		ETag:         strings.Trim(aws.StringValue(result.ETag), "\""),
+		LastModified: aws.TimeValue(result.LastModified),
 	}, nil
 }
 
 func (that *S3Bucket) GetObject(objectName string) ([]byte, error) {
-	result, err := that.client.client.GetObject(&s3.GetObjectInput{
+	return that.GetObjectVersion(objectName, "")
+}
+
+func (that *S3Bucket) GetObjectVersion(objectName string, versionId string) ([]byte, error) {
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(that.config.DocumentBucket),
 		Key:    aws.String(objectName),
-	})
+	}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	result, err := that.client.client.GetObject(input)
 	if err != nil {
 		return nil, err
 	}
@@ -131,11 +144,39 @@ func (that *S3Bucket) GetObject(objectName string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func (that *S3Bucket) GetObjectReader(objectName string, options *GetObjectOptions) (io.ReadCloser, *ObjectInfo, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(that.config.DocumentBucket),
+		Key:    aws.String(objectName),
+	}
+	if options.VersionID != "" {
+		input.VersionId = aws.String(options.VersionID)
+	}
+	if options.Offset > 0 {
+		input.Range = aws.String("bytes=" + strconv.FormatInt(options.Offset, 10) + "-")
+	}
+	if options.MatchETag != "" {
+		input.IfMatch = aws.String(strconv.Quote(options.MatchETag))
+	}
+	result, err := that.client.client.GetObject(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result.Body, &ObjectInfo{
+		Key:          objectName,
+		Size:         aws.Int64Value(result.ContentLength),
+		VersionID:    aws.StringValue(result.VersionId),
+		ETag:         strings.Trim(aws.StringValue(result.ETag), "\""),
+		LastModified: aws.TimeValue(result.LastModified),
+	}, nil
+}
+
 var s3authOpMap = map[int]string{
 	AuthOpGetObject:  "s3:GetObject",
 	AuthOpPutObject:  "s3:PutObject",
 	AuthOpDelObject:  "s3:DeleteObject",
 	AuthOpListObject: "s3:ListBucket",
+	AuthOpGetVersion: "s3:GetObjectVersion",
 }
 
 func (that *S3Bucket) GenerateAccessKey(authPath string, authOp int, expires int, roleSessionName string) (*AccessKeyValue, error) {
@@ -225,6 +266,54 @@ func (that *S3Bucket) DeleteObject(objectName string) error {
 	return err
 }
 
+func (that *S3Bucket) DeleteObjectVersion(objectName string, versionId string) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(that.config.DocumentBucket),
+		Key:    aws.String(objectName),
+	}
+	if versionId != "" {
+		input.VersionId = aws.String(versionId)
+	}
+	_, err := that.client.client.DeleteObject(input)
+	return err
+}
+
+func (that *S3Bucket) ListObjectVersions(prefix string) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		err := that.client.client.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+			Bucket: aws.String(that.config.DocumentBucket),
+			Prefix: aws.String(prefix),
+		}, func(result *s3.ListObjectVersionsOutput, b bool) bool {
+			for _, version := range result.Versions {
+				ch <- ObjectInfo{
+					Key:          aws.StringValue(version.Key),
+					Size:         aws.Int64Value(version.Size),
+					VersionID:    aws.StringValue(version.VersionId),
+					ETag:         strings.Trim(aws.StringValue(version.ETag), "\""),
+					LastModified: aws.TimeValue(version.LastModified),
+					IsLatest:     aws.BoolValue(version.IsLatest),
+				}
+			}
+			for _, marker := range result.DeleteMarkers {
+				ch <- ObjectInfo{
+					Key:            aws.StringValue(marker.Key),
+					VersionID:      aws.StringValue(marker.VersionId),
+					LastModified:   aws.TimeValue(marker.LastModified),
+					IsLatest:       aws.BoolValue(marker.IsLatest),
+					IsDeleteMarker: true,
+				}
+			}
+			return true
+		})
+		if err != nil {
+			ch <- ObjectInfo{Err: err}
+		}
+	}()
+	return ch
+}
+
 func (that *S3Bucket) ListObjects(prefix string) <-chan ObjectInfo {
 	ch := make(chan ObjectInfo)
 	go func() {
@@ -236,9 +325,10 @@ func (that *S3Bucket) ListObjects(prefix string) <-chan ObjectInfo {
 		}, func(result *s3.ListObjectsV2Output, b bool) bool {
 			for _, objectInfo := range result.Contents {
 				ch <- ObjectInfo{
-					Key:       *objectInfo.Key,
-					Size:      *objectInfo.Size,
-					VersionID: "",
+					Key:          *objectInfo.Key,
+					Size:         *objectInfo.Size,
+					VersionID:    "",
+					LastModified: aws.TimeValue(objectInfo.LastModified),
 				}
 			}
 			return true
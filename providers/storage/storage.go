@@ -38,6 +38,8 @@ func NewStoraageClient(config *Config) (*StorageClient, error) {
 		client, err = NewS3Client(&config.ClientConfig)
 	case OSS:
 		client, err = NewOSSClient(&config.ClientConfig)
+	case LOCAL:
+		client, err = NewLocalClient(&config.ClientConfig)
 	default:
 		return nil, errors.New("不支持的provider")
 	}
@@ -16,6 +16,7 @@ import (
 	"kcaitech.com/kcserver/config"
 	"kcaitech.com/kcserver/models"
 	"kcaitech.com/kcserver/providers/auth"
+	"kcaitech.com/kcserver/providers/bus"
 	"kcaitech.com/kcserver/providers/mongo"
 	"kcaitech.com/kcserver/providers/redis"
 	"kcaitech.com/kcserver/providers/safereview"
@@ -67,6 +68,30 @@ func GetRedisDB() *redis.RedisDB {
 	return redisDB
 }
 
+// 使用内存bus的单机部署可以不配置redis
+func HasRedisDB() bool {
+	return redisDB != nil
+}
+
+// bus 的单例
+var _bus bus.Bus
+
+func InitBus(config *config.Configuration) (bus.Bus, error) {
+	if _bus != nil {
+		return _bus, nil
+	}
+	var err error
+	_bus, err = bus.NewBus(&config.Bus, redisDB, &config.Broker)
+	return _bus, err
+}
+
+func GetBus() bus.Bus {
+	if _bus == nil {
+		panic("bus is nil")
+	}
+	return _bus
+}
+
 // MongoDB 的单例
 var mongoDB *mongo.MongoDB
 
@@ -179,7 +204,14 @@ func InitAllBaseServices(config *config.Configuration) error {
 		return err
 	}
 	// 初始化redis
-	_, err = InitRedisDB(&config.Redis)
+	if config.Bus.Provider != bus.Memory || config.Redis.Addr != "" || config.Redis.Sentinel {
+		_, err = InitRedisDB(&config.Redis)
+		if err != nil {
+			return err
+		}
+	}
+	// 初始化bus
+	_, err = InitBus(config)
 	if err != nil {
 		return err
 	}
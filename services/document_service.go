@@ -73,6 +73,7 @@ type ResourceDocumentQueryResItem struct {
 type RecycleBinQueryResItem struct {
 	AccessRecordAndFavoritesQueryResItem
 	DeleteUser *models.UserProfile `gorm:"-" json:"delete_user"`
+	ExpiresAt  *time.Time          `gorm:"-" json:"expires_at,omitempty"` // 到期后彻底删除
 }
 
 // FindRecycleBinByUserId 查询用户的回收站列表
@@ -718,6 +719,35 @@ func NewDocumentVersionService() *DocumentVersionService {
 	return that
 }
 
+// FindByDocumentIdWithCursor 使用游标分页查询文档的版本列表，按创建时间倒序
+func (s *DocumentVersionService) FindByDocumentIdWithCursor(documentId string, namedOnly bool, cursor string, limit int) (*[]models.DocumentVersion, bool) {
+	var result []models.DocumentVersion
+
+	query := "document_id = ?"
+	args := []any{documentId}
+	if namedOnly {
+		query += " and (name != '' or pinned = true)"
+	}
+	// 游标为上一页最后一条记录的id
+	if cursor != "" {
+		query += " and id < ?"
+		args = append(args, cursor)
+	}
+
+	_ = s.Find(
+		&result,
+		&WhereArgs{query, args},
+		&OrderLimitArgs{"id desc", limit + 1},
+	)
+
+	hasMore := false
+	if len(result) > limit {
+		hasMore = true
+		result = result[:limit]
+	}
+	return &result, hasMore
+}
+
 type DocumentPermissionQuery struct {
 	models.BaseModelStruct
 	DocumentPermission models.DocumentPermission `gorm:"embedded;embeddedPrefix:document_permission__" json:"-" table:""`
@@ -741,6 +771,18 @@ func (s *DocumentPermissionService) GetDocumentPermissionByPermId(documentPermis
 	return &result, nil
 }
 
+type DocumentPurgeService struct {
+	*DefaultService
+}
+
+func NewDocumentPurgeService() *DocumentPurgeService {
+	that := &DocumentPurgeService{
+		DefaultService: NewDefaultService(&models.DocumentPurge{}),
+	}
+	that.That = that
+	return that
+}
+
 type ResourceDocumentService struct {
 	*DefaultService
 }
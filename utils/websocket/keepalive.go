@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package websocket
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+)
+
+const CloseReasonIdleTimeout = "idle timeout"
+
+type KeepaliveConfig struct {
+	PingInterval time.Duration // 服务端发送ping的间隔
+	PongTimeout  time.Duration // 超过该时间未收到任何数据（含pong）视为断开
+	IdleTimeout  time.Duration // 超过该时间未收到业务消息则断开，0为不限制
+}
+
+// 由服务端发送ping并设置读超时，半开的连接在PongTimeout内关闭
+func (ws *Ws) StartKeepalive(config KeepaliveConfig) {
+	if ws.ws == nil || config.PingInterval <= 0 || config.PongTimeout <= 0 {
+		return
+	}
+	ws.readTimeout = config.PongTimeout
+	ws.MarkActive()
+	ws.extendReadDeadline()
+	ws.ws.SetPongHandler(func(string) error {
+		ws.extendReadDeadline()
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(config.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ws.connClosed:
+				return
+			}
+			if config.IdleTimeout > 0 && time.Since(time.Unix(0, atomic.LoadInt64(&ws.lastMessageTime))) > config.IdleTimeout {
+				log.Println("ws-keepalive 连接空闲超时")
+				ws.CloseWithReason(gws.CloseGoingAway, CloseReasonIdleTimeout)
+				return
+			}
+			if err := ws.ws.WriteControl(gws.PingMessage, nil, time.Now().Add(WriteTimeout)); err != nil {
+				log.Println("ws-keepalive ping失败", err)
+				ws.closeConn()
+				return
+			}
+		}
+	}()
+}
+
+// 收到消息后延长读超时
+func (ws *Ws) extendReadDeadline() {
+	if ws.readTimeout > 0 {
+		_ = ws.ws.SetReadDeadline(time.Now().Add(ws.readTimeout))
+	}
+}
+
+// 记录业务消息，心跳等消息不应计入
+func (ws *Ws) MarkActive() {
+	atomic.StoreInt64(&ws.lastMessageTime, time.Now().UnixNano())
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+)
+
+var (
+	ErrSlowConsumer = errors.New("客户端消费过慢")
+	errForwarded    = errors.New("已转发到其它连接")
+)
+
+const (
+	// 每个连接待发送消息的上限，超过后断开连接
+	MaxOutboundQueueSize = 1024
+	// 单条消息的写超时
+	WriteTimeout = 10 * time.Second
+
+	CloseReasonSlowConsumer = "slow consumer"
+)
+
+// 所有连接的统计信息
+type Stats struct {
+	Connections        int64 `json:"connections"`
+	QueuedMessages     int64 `json:"queued_messages"`      // 所有连接待发送的消息数
+	CoalescedMessages  int64 `json:"coalesced_messages"`   // 被合并丢弃的消息数
+	SlowConsumerCloses int64 `json:"slow_consumer_closes"` // 因消费过慢断开的连接数
+	WriteFailures      int64 `json:"write_failures"`       // 写失败（含超时）次数
+}
+
+var stats Stats
+
+func GetStats() Stats {
+	return Stats{
+		Connections:        atomic.LoadInt64(&stats.Connections),
+		QueuedMessages:     atomic.LoadInt64(&stats.QueuedMessages),
+		CoalescedMessages:  atomic.LoadInt64(&stats.CoalescedMessages),
+		SlowConsumerCloses: atomic.LoadInt64(&stats.SlowConsumerCloses),
+		WriteFailures:      atomic.LoadInt64(&stats.WriteFailures),
+	}
+}
+
+type outboundMessage struct {
+	messageType MessageType
+	data        []byte
+	key         string // 不为空时，同key的旧消息被新消息替换
+}
+
+// 连接的待发送队列，由单独的协程写入连接，避免慢客户端阻塞订阅协程
+type outbound struct {
+	mutex   sync.Mutex
+	queue   []outboundMessage
+	notify  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	stopped bool
+	forward *Ws // 被其它连接接管后，消息转发到该连接
+}
+
+func newOutbound() *outbound {
+	return &outbound{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (o *outbound) stop() {
+	o.once.Do(func() {
+		o.mutex.Lock()
+		o.stopped = true
+		atomic.AddInt64(&stats.QueuedMessages, -int64(len(o.queue)))
+		o.queue = nil
+		o.mutex.Unlock()
+		close(o.done)
+	})
+}
+
+func (o *outbound) push(msg outboundMessage) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.forward != nil {
+		return errForwarded
+	}
+	if o.stopped {
+		return ErrClosed
+	}
+	if msg.key != "" {
+		// 移除旧消息并追加到末尾，保证与其它消息的先后顺序
+		for i, item := range o.queue {
+			if item.key == msg.key {
+				o.queue = append(o.queue[:i], o.queue[i+1:]...)
+				atomic.AddInt64(&stats.QueuedMessages, -1)
+				atomic.AddInt64(&stats.CoalescedMessages, 1)
+				break
+			}
+		}
+	}
+	if len(o.queue) >= MaxOutboundQueueSize {
+		return ErrSlowConsumer
+	}
+	o.queue = append(o.queue, msg)
+	atomic.AddInt64(&stats.QueuedMessages, 1)
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (o *outbound) popAll() []outboundMessage {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	queue := o.queue
+	o.queue = nil
+	atomic.AddInt64(&stats.QueuedMessages, -int64(len(queue)))
+	return queue
+}
+
+// 写入失败的消息放回队列头部，连接恢复后重发
+func (o *outbound) requeue(msgs []outboundMessage) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.stopped || o.forward != nil || len(msgs) == 0 {
+		return
+	}
+	o.queue = append(append(make([]outboundMessage, 0, len(msgs)+len(o.queue)), msgs...), o.queue...)
+	atomic.AddInt64(&stats.QueuedMessages, int64(len(msgs)))
+}
+
+func (o *outbound) depth() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.queue)
+}
+
+func (ws *Ws) runOutbound() {
+	for {
+		select {
+		case <-ws.outbound.notify:
+		case <-ws.connClosed:
+			return
+		}
+		queue := ws.outbound.popAll()
+		for i, msg := range queue {
+			if err := ws.WriteMessageLock(true, msg.messageType, msg.data); err != nil {
+				atomic.AddInt64(&stats.WriteFailures, 1)
+				log.Println("ws-outbound", err)
+				// 只关闭底层连接，由读协程退出后决定是否保留会话
+				ws.outbound.requeue(queue[i:])
+				ws.closeConn()
+				return
+			}
+		}
+	}
+}
+
+// 转发链上最终接收消息的连接
+func (ws *Ws) target() *Ws {
+	target := ws
+	for {
+		target.outbound.mutex.Lock()
+		forward := target.outbound.forward
+		target.outbound.mutex.Unlock()
+		if forward == nil {
+			return target
+		}
+		target = forward
+	}
+}
+
+func (ws *Ws) send(msg outboundMessage) error {
+	target := ws.target()
+	err := target.outbound.push(msg)
+	if errors.Is(err, errForwarded) {
+		return target.send(msg)
+	}
+	if errors.Is(err, ErrSlowConsumer) {
+		atomic.AddInt64(&stats.SlowConsumerCloses, 1)
+		log.Println("ws-outbound 客户端消费过慢，断开连接")
+		target.CloseWithReason(gws.ClosePolicyViolation, CloseReasonSlowConsumer)
+	}
+	return err
+}
+
+// 加入待发送队列，不等待写入完成
+func (ws *Ws) Send(messageType MessageType, data []byte) error {
+	return ws.send(outboundMessage{messageType: messageType, data: data})
+}
+
+func (ws *Ws) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.Send(MessageTypeText, data)
+}
+
+// 只保留同key的最新消息，用于选区等只关心最新状态的数据
+func (ws *Ws) SendJSONCoalesce(key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.send(outboundMessage{messageType: MessageTypeText, data: data, key: key})
+}
+
+// 待发送的消息数
+func (ws *Ws) QueueDepth() int {
+	return ws.target().outbound.depth()
+}
+
+// 等待待发送消息少于depth，用于批量发送时控制速度
+func (ws *Ws) WaitQueueBelow(ctx context.Context, depth int) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		target := ws.target()
+		if target.outbound.depth() < depth {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-target.outbound.done:
+			if target.target() != target {
+				continue
+			}
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 连接断开后保留待发送队列，等待新连接接管，返回false表示无法保留
+func (ws *Ws) Suspend() bool {
+	ws.outbound.mutex.Lock()
+	defer ws.outbound.mutex.Unlock()
+	if ws.outbound.stopped || ws.closedByServer.Load() {
+		return false
+	}
+	ws.suspended.Store(true)
+	return true
+}
+
+// 丢弃保留的待发送队列
+func (ws *Ws) Release() {
+	ws.outbound.stop()
+}
+
+// 接管old未发送的消息，之后发往old的消息转发到当前连接
+func (ws *Ws) TakeOver(old *Ws) error {
+	old.outbound.mutex.Lock()
+	defer old.outbound.mutex.Unlock()
+	// 保留期间因队列溢出被服务端关闭的会话已丢失消息，不能恢复
+	if old.outbound.stopped || old.outbound.forward != nil || old.closedByServer.Load() {
+		return ErrClosed
+	}
+	ws.outbound.mutex.Lock()
+	if ws.outbound.stopped {
+		ws.outbound.mutex.Unlock()
+		return ErrClosed
+	}
+	if len(ws.outbound.queue)+len(old.outbound.queue) > MaxOutboundQueueSize {
+		ws.outbound.mutex.Unlock()
+		return ErrSlowConsumer
+	}
+	ws.outbound.queue = append(ws.outbound.queue, old.outbound.queue...)
+	ws.outbound.mutex.Unlock()
+	select {
+	case ws.outbound.notify <- struct{}{}:
+	default:
+	}
+	// 消息数已计入统计，移动后不再重复计数
+	old.outbound.queue = nil
+	old.outbound.forward = ws
+	return nil
+}
+
+// 发送关闭帧后关闭连接，由服务端关闭的连接不再保留会话
+func (ws *Ws) CloseWithReason(code int, reason string) {
+	ws.closedByServer.Store(true)
+	if ws.suspended.Load() {
+		// 连接已断开、会话保留中，Close不会再停止队列，这里直接丢弃
+		ws.outbound.stop()
+	}
+	if ws.ws != nil && !ws.isClose {
+		_ = ws.ws.WriteControl(gws.CloseMessage, gws.FormatCloseMessage(code, reason), time.Now().Add(WriteTimeout))
+	}
+	ws.Close()
+}
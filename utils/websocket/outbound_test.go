@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2023-2025 KCai Technology (https://kcaitech.com). All rights reserved.
+ *
+ * This file is part of the Vextra project, which is licensed under the AGPL-3.0 license.
+ * The full license text can be found in the LICENSE file in the root directory of this source tree.
+ *
+ * For more information about the AGPL-3.0 license, please visit:
+ * https://www.gnu.org/licenses/agpl-3.0.html
+ */
+
+package websocket
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOutboundCoalesce(t *testing.T) {
+	o := newOutbound()
+	defer o.stop()
+	_ = o.push(outboundMessage{data: []byte("a1"), key: "a"})
+	_ = o.push(outboundMessage{data: []byte("b")})
+	_ = o.push(outboundMessage{data: []byte("a2"), key: "a"})
+	queue := o.popAll()
+	if len(queue) != 2 {
+		t.Fatalf("期望2条消息，实际%d", len(queue))
+	}
+	// 合并后的消息在后面
+	if string(queue[0].data) != "b" || string(queue[1].data) != "a2" {
+		t.Errorf("顺序错误：%s %s", queue[0].data, queue[1].data)
+	}
+}
+
+func TestOutboundOverflow(t *testing.T) {
+	o := newOutbound()
+	for i := 0; i < MaxOutboundQueueSize; i++ {
+		if err := o.push(outboundMessage{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.push(outboundMessage{}); !errors.Is(err, ErrSlowConsumer) {
+		t.Errorf("队列满时应返回ErrSlowConsumer，实际%v", err)
+	}
+	o.stop()
+	if err := o.push(outboundMessage{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("关闭后应返回ErrClosed，实际%v", err)
+	}
+}
+
+func TestOutboundTakeOver(t *testing.T) {
+	old := &Ws{outbound: newOutbound(), connClosed: make(chan struct{})}
+	ws := &Ws{outbound: newOutbound(), connClosed: make(chan struct{})}
+	defer old.Release()
+	defer ws.Release()
+	_ = old.Send(MessageTypeText, []byte("missed"))
+	if !old.Suspend() {
+		t.Fatal("应当可以保留队列")
+	}
+	_ = ws.Send(MessageTypeText, []byte("first"))
+	if err := ws.TakeOver(old); err != nil {
+		t.Fatal(err)
+	}
+	// 接管后发往旧连接的消息转发到新连接
+	_ = old.Send(MessageTypeText, []byte("after"))
+	queue := ws.outbound.popAll()
+	if len(queue) != 3 || string(queue[1].data) != "missed" || string(queue[2].data) != "after" {
+		t.Errorf("接管后的消息错误：%v", queue)
+	}
+	if err := ws.TakeOver(old); err == nil {
+		t.Error("不能重复接管")
+	}
+}
+
+func TestOutboundTakeOverOverflow(t *testing.T) {
+	old := &Ws{outbound: newOutbound(), connClosed: make(chan struct{})}
+	ws := &Ws{outbound: newOutbound(), connClosed: make(chan struct{})}
+	defer ws.Release()
+	if !old.Suspend() {
+		t.Fatal("应当可以保留队列")
+	}
+	// 保留期间队列溢出，消息已丢失
+	for i := 0; i <= MaxOutboundQueueSize; i++ {
+		_ = old.Send(MessageTypeText, []byte("missed"))
+	}
+	if err := ws.TakeOver(old); err == nil {
+		t.Error("溢出后的会话不能被接管")
+	}
+	if err := old.Send(MessageTypeText, []byte("after")); !errors.Is(err, ErrClosed) {
+		t.Errorf("溢出后应返回ErrClosed，实际%v", err)
+	}
+}
@@ -11,12 +11,17 @@
 package websocket
 
 import (
+	"compress/flate"
+	"encoding/json"
 	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	gws "github.com/gorilla/websocket"
 	"kcaitech.com/kcserver/utils/str"
@@ -38,12 +43,30 @@ type Ws struct {
 	wsReadLock  sync.Mutex
 	isClose     bool
 	handleClose func(int, string)
+
+	compressionNegotiated bool // 握手时协商了permessage-deflate
+	compressThreshold     int  // 大于等于该长度的消息才压缩，0为不压缩
+
+	outbound       *outbound
+	closeOnce      sync.Once
+	connClosed     chan struct{} // 底层连接关闭后发送协程退出
+	connCloseOnce  sync.Once
+	suspended      atomic.Bool // 关闭后保留待发送队列
+	closedByServer atomic.Bool
+
+	readTimeout     time.Duration // 开启keepalive后的读超时
+	lastMessageTime int64         // 最后收到业务消息的时间（纳秒），用于空闲检测
 }
 
 func newWs(ws *gws.Conn) *Ws {
-	return &Ws{
-		ws: ws,
+	w := &Ws{
+		ws:         ws,
+		outbound:   newOutbound(),
+		connClosed: make(chan struct{}),
 	}
+	atomic.AddInt64(&stats.Connections, 1)
+	go w.runOutbound()
+	return w
 }
 
 func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header) (*Ws, error) {
@@ -51,13 +74,17 @@ func Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header)
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
-		EnableCompression: false,
+		// 握手时接受permessage-deflate，是否压缩由客户端在bind时选择
+		EnableCompression: true,
 	}
 	conn, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		return nil, err
 	}
-	return newWs(conn), nil
+	conn.EnableWriteCompression(false)
+	ws := newWs(conn)
+	ws.compressionNegotiated = strings.Contains(r.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	return ws, nil
 }
 
 func NewClient(url string, requestHeader http.Header) (*Ws, error) {
@@ -82,6 +109,23 @@ func (ws *Ws) SetCloseHandler(handler func(code int, text string)) {
 	})
 }
 
+// 开启消息压缩，握手时未协商permessage-deflate则返回false
+func (ws *Ws) EnableCompression(threshold int, level int) bool {
+	ws.wsWriteLock.Lock()
+	defer ws.wsWriteLock.Unlock()
+	if !ws.compressionNegotiated || ws.ws == nil {
+		return false
+	}
+	if level < flate.BestSpeed || level > flate.BestCompression {
+		level = flate.DefaultCompression
+	}
+	if err := ws.ws.SetCompressionLevel(level); err != nil {
+		log.Println("ws-compression-level", err)
+	}
+	ws.compressThreshold = max(threshold, 1)
+	return true
+}
+
 func (ws *Ws) Lock() {
 	ws.wsWriteLock.Lock()
 	ws.wsReadLock.Lock()
@@ -113,8 +157,14 @@ func isGwsClosedError(err error) bool {
 
 const UseOfClosedNetworkConnectionWarn = "use of closed network connection"
 
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 func isClosedError(err error) bool {
-	return isGwsClosedError(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || strings.Contains(err.Error(), UseOfClosedNetworkConnectionWarn)
+	// 读写超时后连接不可再用，视为已关闭
+	return isGwsClosedError(err) || isTimeoutError(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || strings.Contains(err.Error(), UseOfClosedNetworkConnectionWarn)
 }
 
 func (ws *Ws) WriteMessageLock(needLock bool, messageType MessageType, data []byte) error {
@@ -125,6 +175,11 @@ func (ws *Ws) WriteMessageLock(needLock bool, messageType MessageType, data []by
 	if ws.isClose || ws.ws == nil {
 		return ErrClosed
 	}
+	if ws.compressThreshold > 0 {
+		// 小消息压缩收益低，不压缩
+		ws.ws.EnableWriteCompression(len(data) >= ws.compressThreshold)
+	}
+	_ = ws.ws.SetWriteDeadline(time.Now().Add(WriteTimeout))
 	err := ws.ws.WriteMessage(int(messageType), data)
 	if err != nil && isClosedError(err) {
 		log.Println("ws-wr-msg", err)
@@ -141,6 +196,15 @@ func (ws *Ws) WriteJSONLock(needLock bool, v any) error {
 	if ws.isClose || ws.ws == nil {
 		return ErrClosed
 	}
+	if ws.compressThreshold > 0 {
+		// 先编码以确定消息长度
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return ws.WriteMessageLock(false, MessageTypeText, data)
+	}
+	_ = ws.ws.SetWriteDeadline(time.Now().Add(WriteTimeout))
 	err := ws.ws.WriteJSON(v)
 	if err != nil && isClosedError(err) {
 		log.Println("ws-wr-json", err)
@@ -166,6 +230,7 @@ func (ws *Ws) ReadMessageLock(needLock bool) (MessageType, []byte, error) {
 		return MessageTypeNone, data, err
 	}
 	if messageType == int(MessageTypeText) || messageType == int(MessageTypeBinary) {
+		ws.extendReadDeadline()
 		return MessageType(messageType), data, nil
 	}
 	if ws.isClose {
@@ -187,6 +252,9 @@ func (ws *Ws) ReadJSONLock(needLock bool, v any) error {
 		log.Println("ws-rd-json", err)
 		return ErrClosed
 	}
+	if err == nil {
+		ws.extendReadDeadline()
+	}
 	return err
 }
 
@@ -208,16 +276,31 @@ func (ws *Ws) ReadJSON(v any) error {
 
 func (ws *Ws) Close() {
 	log.Println("close ws")
+	ws.closeOnce.Do(func() {
+		if !ws.suspended.Load() {
+			ws.outbound.stop()
+		}
+		atomic.AddInt64(&stats.Connections, -1)
+	})
+	ws.closeConn()
 	if ws.isClose || ws.ws == nil {
 		return
 	}
-	_ = ws.ws.Close()
 	if ws.handleClose != nil {
 		ws.handleClose(0, "")
 	}
 	ws.isClose = true
 }
 
+func (ws *Ws) closeConn() {
+	ws.connCloseOnce.Do(func() {
+		close(ws.connClosed)
+		if ws.ws != nil {
+			_ = ws.ws.Close()
+		}
+	})
+}
+
 func (ws *Ws) IsClose() bool {
 	return ws.isClose
 }